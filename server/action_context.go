@@ -2,14 +2,18 @@ package main
 
 // ActionContext passed from action buttons
 type ActionContext struct {
-	SilenceID string `json:"silence_id"`
-	UserID    string `json:"user_id"`
-	Action    string `json:"action"`
+	SilenceID   string `json:"silence_id"`
+	ReminderKey string `json:"reminder_key"`
+	FlapKey     string `json:"flap_key"`
+	ExpandKey   string `json:"expand_key"`
+	UserID      string `json:"user_id"`
+	Action      string `json:"action"`
 }
 
 // Action type for decoding action buttons
 type Action struct {
-	Context *ActionContext `json:"context"`
-	UserID  string         `json:"user_id"`
-	PostID  string         `json:"post_id"`
+	Context   *ActionContext `json:"context"`
+	UserID    string         `json:"user_id"`
+	PostID    string         `json:"post_id"`
+	ChannelID string         `json:"channel_id"`
 }
@@ -93,6 +93,12 @@ func (p *Plugin) handleExpireAction(w http.ResponseWriter, r *http.Request, aler
 	encodeEphermalMessage(w, silenceDeletedMsg)
 }
 
+// getActionURL returns the URL an action button of the given alert configuration calls back.
+func (p *Plugin) getActionURL(config alertConfig, action string) string {
+	siteURLPort := *p.API.GetConfig().ServiceSettings.ListenAddress
+	return fmt.Sprintf("http://localhost%v/plugins/%v/api/%s?token=%s", siteURLPort, manifest.ID, action, config.Token)
+}
+
 func encodeEphermalMessage(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	payload := map[string]interface{}{
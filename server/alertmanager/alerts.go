@@ -2,24 +2,84 @@ package alertmanager
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/alertmanager/types"
 )
 
 // ListAlerts returns a slice of Alert and an error.
 func ListAlerts(alertmanagerURL string) ([]*types.Alert, error) {
-	resp, err := httpRetry(http.MethodGet, alertmanagerURL+"/api/v2/alerts")
-	if err != nil {
+	return listAlerts(alertmanagerURL + "/api/v2/alerts")
+}
+
+// GettableAlert is an alert listed by Alertmanager, with its status telling whether it is
+// silenced or inhibited.
+type GettableAlert struct {
+	types.Alert
+	Status types.AlertStatus `json:"status"`
+}
+
+// ListAlertsWithStatus returns all alerts, including the silenced and inhibited ones, with their
+// status.
+func ListAlertsWithStatus(alertmanagerURL string) ([]*GettableAlert, error) {
+	var alertResponse []*GettableAlert
+	if err := getJSON(alertmanagerURL+"/api/v2/alerts", &alertResponse); err != nil {
 		return nil, err
 	}
 
+	return alertResponse, nil
+}
+
+func listAlerts(url string) ([]*types.Alert, error) {
 	var alertResponse []*types.Alert
-	dec := json.NewDecoder(resp.Body)
+	if err := getJSON(url, &alertResponse); err != nil {
+		return nil, err
+	}
+
+	return alertResponse, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := httpRetry(http.MethodGet, url)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
-	if errDec := dec.Decode(&alertResponse); errDec != nil {
-		return nil, errDec
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// PostableAlert is an alert sent to Alertmanager. Alertmanager starts it now if StartsAt is zero,
+// and resolves it after its resolve timeout if EndsAt is zero.
+type PostableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// PostAlerts sends alerts to Alertmanager, which routes and notifies them like the alerts of
+// Prometheus.
+func PostAlerts(alerts []PostableAlert, alertmanagerURL string) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpRetryWithBody(http.MethodPost, alertmanagerURL+"/api/v2/alerts", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status code is %d: %s", resp.StatusCode, respBody)
 	}
 
-	return alertResponse, err
+	return nil
 }
@@ -0,0 +1,33 @@
+package alertmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/alertmanager/types"
+)
+
+func TestListAlertsWithStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		assert.Empty(t, r.URL.RawQuery)
+		_, _ = w.Write([]byte(`[
+			{"labels": {"alertname": "DiskFull"}, "startsAt": "2023-06-01T10:00:00Z", "status": {"state": "active", "silencedBy": [], "inhibitedBy": []}},
+			{"labels": {"alertname": "HighLatency"}, "startsAt": "2023-06-01T10:00:00Z", "status": {"state": "suppressed", "silencedBy": ["s1"], "inhibitedBy": []}}
+		]`))
+	}))
+	defer server.Close()
+
+	alerts, err := ListAlertsWithStatus(server.URL)
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "DiskFull", alerts[0].Name())
+	assert.Equal(t, types.AlertStateActive, alerts[0].Status.State)
+	assert.Equal(t, "HighLatency", alerts[1].Name())
+	assert.Equal(t, types.AlertStateSuppressed, alerts[1].Status.State)
+	assert.Equal(t, []string{"s1"}, alerts[1].Status.SilencedBy)
+}
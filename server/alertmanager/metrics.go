@@ -0,0 +1,62 @@
+package alertmanager
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "mattermost_plugin_alertmanager"
+
+var (
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of the Alertmanager API calls, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "method"})
+
+	apiRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "api",
+		Name:      "request_errors_total",
+		Help:      "Number of Alertmanager API calls that failed after all retries.",
+	}, []string{"endpoint", "method"})
+
+	apiRequestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "api",
+		Name:      "request_retries_total",
+		Help:      "Number of retried Alertmanager API requests.",
+	}, []string{"endpoint", "method"})
+)
+
+// RegisterMetrics registers the metrics of the Alertmanager API calls.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{apiRequestDuration, apiRequestErrors, apiRequestRetries} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apiEndpoint returns the API endpoint of a URL for the metric labels, e.g. "silence" for
+// http://alertmanager:9093/api/v2/silence/<ID>, so that IDs do not end up in labels.
+func apiEndpoint(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+
+	_, path, ok := strings.Cut(u.Path, "/api/v2/")
+	if !ok {
+		return "unknown"
+	}
+
+	endpoint, _, _ := strings.Cut(path, "/")
+	return endpoint
+}
@@ -0,0 +1,14 @@
+package alertmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	assert.Equal(t, "alerts", apiEndpoint("http://alertmanager:9093/api/v2/alerts?silenced=false"))
+	assert.Equal(t, "silence", apiEndpoint("http://alertmanager:9093/prefix/api/v2/silence/6f2d3c1e"))
+	assert.Equal(t, "status", apiEndpoint("http://alertmanager:9093/api/v2/status"))
+	assert.Equal(t, "unknown", apiEndpoint("http://alertmanager:9093/-/healthy"))
+}
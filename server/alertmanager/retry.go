@@ -1,14 +1,19 @@
 package alertmanager
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/cenkalti/backoff"
 )
 
+// maxErrorBodySize limits how much of the body of a failed response is included in its error.
+const maxErrorBodySize = 1 << 10
+
 func httpBackoff() *backoff.ExponentialBackOff {
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = 200 * time.Millisecond
@@ -18,17 +23,37 @@ func httpBackoff() *backoff.ExponentialBackOff {
 }
 
 func httpRetry(method string, url string) (*http.Response, error) {
+	return httpRetryWithBody(method, url, nil)
+}
+
+// httpRetryWithBody is httpRetry sending body as a JSON payload.
+func httpRetryWithBody(method string, url string, body []byte) (*http.Response, error) {
 	var resp *http.Response
 	var err error
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	endpoint := apiEndpoint(url)
+	start := time.Now()
+	defer func() {
+		apiRequestDuration.WithLabelValues(endpoint, method).Observe(time.Since(start).Seconds())
+	}()
+
+	attempts := 0
 	fn := func() error {
-		req, errReq := http.NewRequest(method, url, nil)
+		attempts++
+		if attempts > 1 {
+			apiRequestRetries.WithLabelValues(endpoint, method).Inc()
+		}
+
+		req, errReq := http.NewRequest(method, url, bytes.NewReader(body))
 		if errReq != nil {
 			return errReq
 		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
 
 		req = req.WithContext(ctx)
 		resp, err = http.DefaultClient.Do(req) // nolint: bodyclose
@@ -36,23 +61,31 @@ func httpRetry(method string, url string) (*http.Response, error) {
 			return err
 		}
 
-		switch method {
-		case http.MethodGet:
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("status code is %d not 200", resp.StatusCode)
-			}
-		case http.MethodPost:
-			if resp.StatusCode == http.StatusBadRequest {
-				return fmt.Errorf("status code is %d not 3xx", resp.StatusCode)
+		if resp.StatusCode >= http.StatusBadRequest || (method == http.MethodGet && resp.StatusCode != http.StatusOK) {
+			errStatus := statusError(resp)
+			// Retrying a request that Alertmanager rejected does not change its answer.
+			if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+				return backoff.Permanent(errStatus)
 			}
+			return errStatus
 		}
 
 		return nil
 	}
 
 	if errRetry := backoff.Retry(fn, httpBackoff()); errRetry != nil {
+		apiRequestErrors.WithLabelValues(endpoint, method).Inc()
 		return nil, errRetry
 	}
 
 	return resp, err
 }
+
+// statusError returns the error of a response with an unexpected status code, including the start
+// of its body, in which Alertmanager explains the error. It closes the body.
+func statusError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return fmt.Errorf("status code is %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+}
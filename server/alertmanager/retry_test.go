@@ -0,0 +1,46 @@
+package alertmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPRetryClientError(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":400,"message":"start time must be before end time"}`))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, err := httpRetryWithBody(http.MethodPost, server.URL+"/api/v2/alerts", []byte(`[]`))
+	require.Error(t, err)
+	assert.Equal(t, `status code is 400: {"code":400,"message":"start time must be before end time"}`, err.Error())
+	assert.Equal(t, int32(1), requests.Load(), "client errors must not be retried")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestHTTPRetryServerError(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	resp, err := httpRetry(http.MethodGet, server.URL+"/api/v2/alerts")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(2), requests.Load())
+}
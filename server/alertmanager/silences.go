@@ -9,6 +9,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/prometheus/alertmanager/pkg/labels"
 	"github.com/prometheus/alertmanager/types"
 )
 
@@ -34,6 +35,87 @@ func ListSilences(alertmanagerURL string) ([]types.Silence, error) {
 	return silences, err
 }
 
+// GetSilence returns the silence with the given ID.
+func GetSilence(silenceID, alertmanagerURL string) (types.Silence, error) {
+	var silence types.Silence
+	if silenceID == "" {
+		return silence, fmt.Errorf("silence ID cannot be empty")
+	}
+
+	resp, err := httpRetry(http.MethodGet, fmt.Sprintf("%s/api/v2/silence/%s", alertmanagerURL, silenceID))
+	if err != nil {
+		return silence, err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	defer resp.Body.Close()
+	if errDec := dec.Decode(&silence); errDec != nil {
+		return silence, errDec
+	}
+
+	return silence, nil
+}
+
+// postableSilence is the payload Alertmanager accepts to create or update a silence.
+type postableSilence struct {
+	ID        string          `json:"id,omitempty"`
+	Matchers  labels.Matchers `json:"matchers"`
+	StartsAt  time.Time       `json:"startsAt"`
+	EndsAt    time.Time       `json:"endsAt"`
+	CreatedBy string          `json:"createdBy"`
+	Comment   string          `json:"comment"`
+}
+
+// CreateSilence creates a silence, or updates it if the silence has an ID, and returns its ID.
+func CreateSilence(silence types.Silence, alertmanagerURL string) (string, error) {
+	body, err := json.Marshal(postableSilence{
+		ID:        silence.ID,
+		Matchers:  silence.Matchers,
+		StartsAt:  silence.StartsAt,
+		EndsAt:    silence.EndsAt,
+		CreatedBy: silence.CreatedBy,
+		Comment:   silence.Comment,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpRetryWithBody(http.MethodPost, alertmanagerURL+"/api/v2/silences", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", errors.New(string(respBody))
+	}
+
+	var silenceResponse struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if errDec := json.NewDecoder(resp.Body).Decode(&silenceResponse); errDec != nil {
+		return "", errDec
+	}
+
+	return silenceResponse.SilenceID, nil
+}
+
+// ExtendSilence moves the end of a silence by the given duration and returns the updated silence.
+func ExtendSilence(silenceID, alertmanagerURL string, d time.Duration) (types.Silence, error) {
+	silence, err := GetSilence(silenceID, alertmanagerURL)
+	if err != nil {
+		return silence, err
+	}
+
+	silence.EndsAt = silence.EndsAt.Add(d)
+	if _, err := CreateSilence(silence, alertmanagerURL); err != nil {
+		return silence, err
+	}
+
+	return silence, nil
+}
+
 // DeleteSilence delete a silence by ID.
 func ExpireSilence(silenceID, alertmanagerURL string) error {
 	if silenceID == "" {
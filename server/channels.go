@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	alertChannelKeyPrefix = "alertchannel_"
+
+	channelTypePublic  = "public"
+	channelTypePrivate = "private"
+)
+
+// alertChannel is the channel an alert config was last resolved to.
+type alertChannel struct {
+	Team      string
+	Channel   string
+	ChannelID string
+}
+
+// getAlertChannelID returns the ID of the channel of an alert config, or an empty string if the
+// channel could not be ensured.
+func (p *Plugin) getAlertChannelID(configID string) string {
+	p.channelsLock.RLock()
+	defer p.channelsLock.RUnlock()
+
+	return p.alertConfigIDChannelID[configID]
+}
+
+// getAlertChannelError returns why the channel of an alert config could not be ensured, or an
+// empty string if it was.
+func (p *Plugin) getAlertChannelError(configID string) string {
+	p.channelsLock.RLock()
+	defer p.channelsLock.RUnlock()
+
+	return p.alertChannelErrors[configID]
+}
+
+// updateAlertChannels ensures the channels of the alert configs added, or whose channel changed,
+// from previous to current, and swaps in the channels of current at once. The channels of the
+// unchanged alert configs are kept as they are. A channel resolved for several alert configs, by
+// name or by ID, is only given to the one that already had it, or else to the first one by ID.
+func (p *Plugin) updateAlertChannels(previous, current *configuration) {
+	p.channelsLock.RLock()
+	channelIDs := make(map[string]string, len(current.AlertConfigs))
+	channelErrors := make(map[string]string)
+	// The channels kept were only given to one alert config.
+	configIDs := make(map[string]string)
+	for id, config := range current.AlertConfigs {
+		previousConfig, ok := previous.AlertConfigs[id]
+		if channelID := p.alertConfigIDChannelID[id]; ok && channelID != "" && !alertChannelChanged(previousConfig, config) {
+			channelIDs[id] = channelID
+			configIDs[channelID] = id
+		}
+	}
+	p.channelsLock.RUnlock()
+
+	for _, id := range sortedConfigIDs(current.AlertConfigs) {
+		if _, ok := channelIDs[id]; ok {
+			continue
+		}
+
+		channelID, err := p.ensureAlertChannelExists(current.AlertConfigs[id])
+		if err != nil {
+			p.API.LogWarn(fmt.Sprintf("Failed to ensure alert channel %v", id), "error", err.Error())
+			channelErrors[id] = err.Error()
+			continue
+		}
+		if other, ok := configIDs[channelID]; ok {
+			p.API.LogWarn(fmt.Sprintf("Alert channel %v is already used by alert config %v", id, other))
+			channelErrors[id] = fmt.Sprintf("same channel as alert config %s", other)
+			continue
+		}
+		channelIDs[id] = channelID
+		configIDs[channelID] = id
+	}
+
+	p.channelsLock.Lock()
+	p.alertConfigIDChannelID = channelIDs
+	p.alertChannelErrors = channelErrors
+	p.channelsLock.Unlock()
+}
+
+// refreshAlertChannel ensures the channel of an alert config again, such as after a post to it
+// failed because it was deleted or archived, and returns its ID, or an empty string if it could
+// not be ensured. The channels of the other alert configs are kept as they are. The channel is
+// not refreshed if the alert config was removed or its channel changed by a reload, which
+// ensured it.
+func (p *Plugin) refreshAlertChannel(config alertConfig) string {
+	p.reloadLock.RLock()
+	defer p.reloadLock.RUnlock()
+
+	current, ok := p.getConfiguration().AlertConfigs[config.ID]
+	if !ok || alertChannelChanged(config, current) {
+		return p.getAlertChannelID(config.ID)
+	}
+
+	channelID, err := p.ensureAlertChannelExists(current)
+
+	p.channelsLock.Lock()
+	if err == nil {
+		for id, otherChannelID := range p.alertConfigIDChannelID {
+			if id != config.ID && otherChannelID == channelID {
+				err = fmt.Errorf("same channel as alert config %s", id)
+			}
+		}
+	}
+
+	channelIDs := make(map[string]string, len(p.alertConfigIDChannelID))
+	for id, otherChannelID := range p.alertConfigIDChannelID {
+		channelIDs[id] = otherChannelID
+	}
+	channelErrors := make(map[string]string, len(p.alertChannelErrors))
+	for id, channelErr := range p.alertChannelErrors {
+		channelErrors[id] = channelErr
+	}
+	if err != nil {
+		channelID = ""
+		delete(channelIDs, config.ID)
+		channelErrors[config.ID] = err.Error()
+	} else {
+		channelIDs[config.ID] = channelID
+		delete(channelErrors, config.ID)
+	}
+	p.alertConfigIDChannelID = channelIDs
+	p.alertChannelErrors = channelErrors
+	p.channelsLock.Unlock()
+
+	if err != nil {
+		p.API.LogWarn(fmt.Sprintf("Failed to ensure alert channel %v", config.ID), "error", err.Error())
+	}
+
+	return channelID
+}
+
+// alertChannelChanged reports whether the channel of an alert config must be ensured again.
+func alertChannelChanged(previous, current alertConfig) bool {
+	return previous.Team != current.Team || previous.Channel != current.Channel
+}
+
+// ensureAlertChannelExists returns the ID of the channel of an alert config, creating the
+// channel if it does not exist and the config allows it. The bot is added to private channels.
+func (p *Plugin) ensureAlertChannelExists(alertConfig alertConfig) (string, error) {
+	if err := alertConfig.IsValid(); err != nil {
+		return "", fmt.Errorf("alert Configuration is invalid: %w", err)
+	}
+
+	team, appErr := p.API.GetTeamByName(alertConfig.Team)
+	if appErr != nil {
+		return "", fmt.Errorf("failed to get team: %w", appErr)
+	}
+
+	channel, err := p.findAlertChannel(alertConfig, team.Id)
+	if err != nil {
+		return "", err
+	}
+
+	if channel == nil {
+		if alertConfig.DisableChannelCreation {
+			return "", fmt.Errorf("channel %s not found in team %s, and channel creation is disabled", alertConfig.Channel, alertConfig.Team)
+		}
+
+		channelToCreate := &model.Channel{
+			Name:        alertConfig.Channel,
+			DisplayName: alertConfig.Channel,
+			Type:        alertChannelType(alertConfig),
+			TeamId:      team.Id,
+			CreatorId:   p.BotUserID,
+		}
+
+		channel, appErr = p.API.CreateChannel(channelToCreate)
+		if appErr != nil {
+			return "", fmt.Errorf("failed to create alert channel: %w", appErr)
+		}
+	}
+
+	if channel.DeleteAt != 0 {
+		return "", fmt.Errorf("channel %s of team %s is archived, unarchive it or configure another channel", channel.Name, alertConfig.Team)
+	}
+
+	if channel.Type == model.ChannelTypePrivate {
+		if _, appErr = p.API.GetChannelMember(channel.Id, p.BotUserID); appErr != nil {
+			if _, appErr = p.API.AddChannelMember(channel.Id, p.BotUserID); appErr != nil {
+				return "", fmt.Errorf("failed to add the bot to private channel %s, make sure it is a member of team %s: %w", channel.Name, alertConfig.Team, appErr)
+			}
+		}
+	}
+
+	resolved := alertChannel{Team: alertConfig.Team, Channel: alertConfig.Channel, ChannelID: channel.Id}
+	if _, err = p.client.KV.Set(alertChannelKeyPrefix+alertConfig.ID, resolved); err != nil {
+		p.API.LogWarn("Failed to store the alert channel", "config", alertConfig.ID, "error", err.Error())
+	}
+
+	return channel.Id, nil
+}
+
+// findAlertChannel returns the channel of an alert config in its team, including archived
+// channels, or nil if it does not exist. Channel is either the ID or the name of the channel. A
+// channel the config was resolved to before keeps being used after it is renamed.
+func (p *Plugin) findAlertChannel(alertConfig alertConfig, teamID string) (*model.Channel, error) {
+	var previous alertChannel
+	if err := p.client.KV.Get(alertChannelKeyPrefix+alertConfig.ID, &previous); err != nil {
+		p.API.LogWarn("Failed to get the alert channel", "config", alertConfig.ID, "error", err.Error())
+	}
+	if previous.ChannelID != "" && previous.Team == alertConfig.Team && previous.Channel == alertConfig.Channel {
+		channel, appErr := p.API.GetChannel(previous.ChannelID)
+		if appErr == nil && channel.TeamId == teamID {
+			if channel.Id != alertConfig.Channel && channel.Name != alertConfig.Channel {
+				p.API.LogInfo("Alert channel was renamed, update the alert config to its new name or to its ID",
+					"config", alertConfig.ID, "channel", alertConfig.Channel, "name", channel.Name)
+			}
+			return channel, nil
+		}
+	}
+
+	if model.IsValidId(alertConfig.Channel) {
+		channel, appErr := p.API.GetChannel(alertConfig.Channel)
+		if appErr == nil {
+			if channel.TeamId != teamID {
+				return nil, fmt.Errorf("channel %s is not in team %s", alertConfig.Channel, alertConfig.Team)
+			}
+			return channel, nil
+		}
+		if appErr.StatusCode != http.StatusNotFound {
+			return nil, fmt.Errorf("failed to get existing alert channel: %w", appErr)
+		}
+	}
+
+	channel, appErr := p.API.GetChannelByName(teamID, alertConfig.Channel, true)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get existing alert channel: %w", appErr)
+	}
+
+	return channel, nil
+}
+
+// alertChannelType returns the type of the channel created for an alert config.
+func alertChannelType(alertConfig alertConfig) model.ChannelType {
+	if alertConfig.ChannelType == channelTypePrivate {
+		return model.ChannelTypePrivate
+	}
+
+	return model.ChannelTypeOpen
+}
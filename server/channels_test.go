@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertChannelChanged(t *testing.T) {
+	config := alertConfig{Team: "team", Channel: "alerts", RenderMode: "compact"}
+
+	assert.False(t, alertChannelChanged(config, alertConfig{Team: "team", Channel: "alerts"}))
+	assert.True(t, alertChannelChanged(config, alertConfig{Team: "team", Channel: "db-alerts"}))
+	assert.True(t, alertChannelChanged(config, alertConfig{Team: "other", Channel: "alerts"}))
+}
+
+func TestConfigurationReload(t *testing.T) {
+	pluginConfigs := []string{`{"alertconfigs": {
+		"0": {"token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"},
+		"1": {"token": "vutsrqponmlkjihgfedcba9876543210", "team": "team", "channel": "db-alerts", "alertmanagerurl": "http://alertmanager:9093"}
+	}}`, `{"alertconfigs": {
+		"0": {"token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093", "rendermode": "compact"},
+		"1": {"token": "vutsrqponmlkjihgfedcba9876543210", "team": "team", "channel": "infra-alerts", "alertmanagerurl": "http://alertmanager:9093"}
+	}}`}
+
+	var loads atomic.Int64
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		pluginConfig := pluginConfigs[loads.Add(1)%2]
+		require.NoError(t, json.Unmarshal([]byte(pluginConfig), args.Get(0)))
+	})
+	api.On("GetTeamByName", "team").Return(&model.Team{Id: "team-id", Name: "team"}, nil)
+	api.On("GetChannelByName", "team-id", mock.AnythingOfType("string"), true).Return(func(_, name string, _ bool) *model.Channel {
+		return &model.Channel{Id: name + "-id", Name: name, TeamId: "team-id", Type: model.ChannelTypeOpen}
+	}, nil)
+	api.On("KVGet", mock.AnythingOfType("string")).Return(nil, nil)
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(true, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+
+	require.NoError(t, p.OnConfigurationChange())
+	assert.Equal(t, "alerts-id", p.getAlertChannelID("0"))
+
+	// Webhooks keep reading the configuration and the channels while it is reloaded.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for id := range p.getConfiguration().AlertConfigs {
+					channelID := p.getAlertChannelID(id)
+					assert.Contains(t, []string{"alerts-id", "db-alerts-id", "infra-alerts-id"}, channelID)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, p.OnConfigurationChange())
+	}
+	close(done)
+	wg.Wait()
+
+	// The channel of the unchanged alert config was only ensured on the first load.
+	api.AssertNumberOfCalls(t, "GetChannelByName", 2+50)
+	assert.Equal(t, "alerts-id", p.getAlertChannelID("0"))
+	assert.Equal(t, "infra-alerts-id", p.getAlertChannelID("1"))
+}
+
+func TestEnsureAlertChannelExists(t *testing.T) {
+	channelID := model.NewId()
+	notFound := model.NewAppError("GetChannel", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound)
+
+	setup := func(previous *alertChannel) (*Plugin, *plugintest.API) {
+		api := &plugintest.API{}
+		api.On("GetTeamByName", "team").Return(&model.Team{Id: "team-id", Name: "team"}, nil)
+		if previous != nil {
+			b, err := json.Marshal(previous)
+			require.NoError(t, err)
+			api.On("KVGet", alertChannelKeyPrefix+"0").Return(b, nil)
+		} else {
+			api.On("KVGet", alertChannelKeyPrefix+"0").Return(nil, nil)
+		}
+		api.On("KVSetWithOptions", alertChannelKeyPrefix+"0", mock.Anything, mock.Anything).Return(true, nil)
+
+		p := &Plugin{BotUserID: "bot-id"}
+		p.SetAPI(api)
+		p.client = pluginapi.NewClient(api, nil)
+		return p, api
+	}
+
+	t.Run("by ID", func(t *testing.T) {
+		p, _ := setup(nil)
+		p.API.(*plugintest.API).On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+
+		config := validAlertConfig()
+		config.Channel = channelID
+		id, err := p.ensureAlertChannelExists(config)
+		require.NoError(t, err)
+		assert.Equal(t, channelID, id)
+	})
+
+	t.Run("by ID in another team", func(t *testing.T) {
+		p, _ := setup(nil)
+		p.API.(*plugintest.API).On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "other-team-id"}, nil)
+
+		config := validAlertConfig()
+		config.Channel = channelID
+		_, err := p.ensureAlertChannelExists(config)
+		assert.EqualError(t, err, "channel "+channelID+" is not in team team")
+	})
+
+	t.Run("archived", func(t *testing.T) {
+		p, _ := setup(nil)
+		p.API.(*plugintest.API).On("GetChannelByName", "team-id", "alerts", true).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "team-id", DeleteAt: 1}, nil)
+
+		_, err := p.ensureAlertChannelExists(validAlertConfig())
+		assert.EqualError(t, err, "channel alerts of team team is archived, unarchive it or configure another channel")
+	})
+
+	t.Run("creation disabled", func(t *testing.T) {
+		p, _ := setup(nil)
+		p.API.(*plugintest.API).On("GetChannelByName", "team-id", "alerts", true).Return(nil, notFound)
+
+		config := validAlertConfig()
+		config.DisableChannelCreation = true
+		_, err := p.ensureAlertChannelExists(config)
+		assert.EqualError(t, err, "channel alerts not found in team team, and channel creation is disabled")
+	})
+
+	t.Run("private channel created", func(t *testing.T) {
+		p, api := setup(nil)
+		api.On("GetChannelByName", "team-id", "alerts", true).Return(nil, notFound)
+		api.On("CreateChannel", mock.MatchedBy(func(channel *model.Channel) bool {
+			return channel.Type == model.ChannelTypePrivate && channel.TeamId == "team-id"
+		})).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "team-id", Type: model.ChannelTypePrivate}, nil)
+		api.On("GetChannelMember", channelID, "bot-id").Return(nil, notFound)
+		api.On("AddChannelMember", channelID, "bot-id").Return(&model.ChannelMember{}, nil)
+
+		config := validAlertConfig()
+		config.ChannelType = channelTypePrivate
+		id, err := p.ensureAlertChannelExists(config)
+		require.NoError(t, err)
+		assert.Equal(t, channelID, id)
+		api.AssertCalled(t, "AddChannelMember", channelID, "bot-id")
+	})
+
+	t.Run("renamed", func(t *testing.T) {
+		p, api := setup(&alertChannel{Team: "team", Channel: "alerts", ChannelID: channelID})
+		api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Name: "prod-alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+		api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		id, err := p.ensureAlertChannelExists(validAlertConfig())
+		require.NoError(t, err)
+		assert.Equal(t, channelID, id)
+		api.AssertNotCalled(t, "GetChannelByName", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUpdateAlertChannelsSameChannel(t *testing.T) {
+	channelID := model.NewId()
+
+	api := &plugintest.API{}
+	mockKVStore(api)
+	mockLogs(api)
+	api.On("GetTeamByName", "team").Return(&model.Team{Id: "team-id", Name: "team"}, nil)
+	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetChannelByName", "team-id", "alerts", true).Return(&model.Channel{Id: channelID, Name: "alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+
+	// The channel is configured by ID and by name.
+	byID := validAlertConfig()
+	byID.ID = "1"
+	byID.Token = "vutsrqponmlkjihgfedcba9876543210"
+	byID.Channel = channelID
+	previous := &configuration{AlertConfigs: map[string]alertConfig{"1": byID}}
+	p.updateAlertChannels(&configuration{}, previous)
+	assert.Equal(t, channelID, p.getAlertChannelID("1"))
+
+	// The alert config that already had the channel keeps it.
+	current := previous.Clone()
+	current.AlertConfigs["0"] = validAlertConfig()
+	p.updateAlertChannels(previous, current)
+	assert.Equal(t, channelID, p.getAlertChannelID("1"))
+	assert.Empty(t, p.getAlertChannelID("0"))
+	assert.Equal(t, "same channel as alert config 1", p.getAlertChannelError("0"))
+
+	// Otherwise the first one by ID gets it.
+	p.updateAlertChannels(&configuration{}, current)
+	assert.Equal(t, channelID, p.getAlertChannelID("0"))
+	assert.Equal(t, "same channel as alert config 0", p.getAlertChannelError("1"))
+}
+
+func TestRefreshAlertChannel(t *testing.T) {
+	notFound := model.NewAppError("GetChannel", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound)
+
+	p, api, _ := newTestPlugin(t)
+	api.On("GetTeamByName", "team").Return(&model.Team{Id: "team-id", Name: "team"}, nil)
+	api.On("GetChannel", "deleted-id").Return(nil, notFound)
+	api.On("GetChannel", "alerts-id").Return(&model.Channel{Id: "alerts-id", Name: "alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetChannelByName", "team-id", "alerts", true).Return(&model.Channel{Id: "alerts-id", Name: "alerts", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "deleted-id"
+	})).Return(nil, notFound)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+
+	config := validAlertConfig()
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "deleted-id"}
+	_, err := p.client.KV.Set(alertChannelKeyPrefix+"0", alertChannel{Team: "team", Channel: "alerts", ChannelID: "deleted-id"})
+	require.NoError(t, err)
+
+	d := &delivery{
+		ID:       model.NewId(),
+		ConfigID: "0",
+		Message:  newWebhookMessage("mattermost", "group", "http://alertmanager:9093", template.KV{"alertname": "DiskFull"}, template.Alerts{{Status: "firing"}}),
+	}
+
+	// The failed post finds the channel that replaced the deleted one, for the retry.
+	assert.Error(t, p.processWebhookMessage(config, d))
+	assert.Equal(t, "alerts-id", p.getAlertChannelID("0"))
+	var resolved alertChannel
+	require.NoError(t, p.client.KV.Get(alertChannelKeyPrefix+"0", &resolved))
+	assert.Equal(t, "alerts-id", resolved.ChannelID)
+
+	assert.NoError(t, p.processWebhookMessage(config, d))
+	assert.Equal(t, []string{"deleted-id", "alerts-id"}, postedChannels(api))
+
+	// A channel that could not be ensured is ensured again before posting.
+	p.alertConfigIDChannelID = map[string]string{}
+	p.alertChannelErrors = map[string]string{"0": "failed to get team"}
+	assert.NoError(t, p.processWebhookMessage(config, &delivery{ID: model.NewId(), ConfigID: "0", Message: d.Message}))
+	assert.Equal(t, []string{"deleted-id", "alerts-id", "alerts-id"}, postedChannels(api))
+	assert.Empty(t, p.getAlertChannelError("0"))
+}
+
+func TestRefreshAlertChannelReloaded(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	p.alertConfigIDChannelID = map[string]string{"1": "db-alerts-id"}
+
+	// The alert config was removed by a reload.
+	assert.Empty(t, p.refreshAlertChannel(validAlertConfig()))
+	assert.Equal(t, map[string]string{"1": "db-alerts-id"}, p.alertConfigIDChannelID)
+
+	// The channel of the alert config was changed, and ensured, by a reload.
+	config := validAlertConfig()
+	config.Channel = "prod-alerts"
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "prod-alerts-id"}
+	assert.Equal(t, "prod-alerts-id", p.refreshAlertChannel(validAlertConfig()))
+	api.AssertNotCalled(t, "GetTeamByName", mock.Anything)
+}
@@ -25,6 +25,16 @@ const (
 	/alertmanager silences - to list the existing silences
 	/alertmanager expire_silence - to expire a silence
 	/alertmanager status - to list the version and uptime of the Alertmanager instance
+	/alertmanager digest - to manage the scheduled alert digests of this channel
+	/alertmanager config - to manage the alert configurations (system admins only)
+	/alertmanager setup [AlertManager Config ID] - to show the Alertmanager receiver configuration of an alert configuration (system admins only)
+	/alertmanager test [AlertManager Config ID] [firing|resolved] [label=value...] [--send] - to post a test alert, or with --send to send it to Alertmanager (channel admins only)
+	/alertmanager fire [AlertManager Config ID] - to fire an alert in Alertmanager (admins of the alert channel only)
+	/alertmanager subscribe [AlertManager Config ID] [matchers] - to post the alerts of a configuration matching the comma-separated matchers to this channel (channel admins only)
+	/alertmanager unsubscribe [Subscription ID|AlertManager Config ID] - to remove subscriptions of this channel (channel admins only)
+	/alertmanager subscriptions - to list the subscriptions of this channel
+	/alertmanager deadletters - to list the notifications that could not be posted (system admins only)
+	/alertmanager retry [Dead Letter ID|all] - to queue dead letters for delivery again (system admins only)
 	/alertmanager help - display Slash Command help text"
 	/alertmanager about - display build information
 	`
@@ -39,7 +49,7 @@ func (p *Plugin) getCommand() (*model.Command, error) {
 	return &model.Command{
 		Trigger:              "alertmanager",
 		AutoComplete:         true,
-		AutoCompleteDesc:     fmt.Sprintf("Available commands: status, alerts, silences, expire_silence, %s, %s", actionHelp, actionAbout),
+		AutoCompleteDesc:     fmt.Sprintf("Available commands: status, alerts, silences, expire_silence, digest, config, setup, test, fire, subscribe, unsubscribe, subscriptions, deadletters, retry, %s, %s", actionHelp, actionAbout),
 		AutoCompleteHint:     "[command]",
 		AutocompleteData:     getAutocompleteData(),
 		AutocompleteIconData: iconData,
@@ -47,7 +57,7 @@ func (p *Plugin) getCommand() (*model.Command, error) {
 }
 
 func getAutocompleteData() *model.AutocompleteData {
-	root := model.NewAutocompleteData("alertmanager", "[command]", fmt.Sprintf("Available commands: status, alerts, silences, expire_silence, %s, %s", actionHelp, actionAbout))
+	root := model.NewAutocompleteData("alertmanager", "[command]", fmt.Sprintf("Available commands: status, alerts, silences, expire_silence, digest, config, setup, test, fire, subscribe, unsubscribe, subscriptions, deadletters, retry, %s, %s", actionHelp, actionAbout))
 
 	alerts := model.NewAutocompleteData("alerts", "", "List the existing alerts")
 	root.AddCommand(alerts)
@@ -63,6 +73,57 @@ func getAutocompleteData() *model.AutocompleteData {
 	status := model.NewAutocompleteData("status", "", "List the version and uptime of the Alertmanager instance")
 	root.AddCommand(status)
 
+	digest := model.NewAutocompleteData("digest", "[command]", "Manage the scheduled alert digests of this channel")
+	digestAdd := model.NewAutocompleteData("add", "[cron expression] [timezone] [AlertManager Config ID...]", "Post a digest of active alerts to this channel on a schedule")
+	digestAdd.AddTextArgument("Cron expression, timezone and optional alert configurations", "0 9 * * 1-5 Europe/Berlin [AlertManager Config ID...]", "")
+	digest.AddCommand(digestAdd)
+	digest.AddCommand(model.NewAutocompleteData("list", "", "List the digests scheduled for this channel"))
+	digestRemove := model.NewAutocompleteData("remove", "[Digest ID]", "Remove a scheduled digest")
+	digestRemove.AddTextArgument("The ID of the digest to remove", "[Digest ID]", "")
+	digest.AddCommand(digestRemove)
+	root.AddCommand(digest)
+
+	config := model.NewAutocompleteData("config", "[command]", "Manage the alert configurations")
+	configAdd := model.NewAutocompleteData("add", "[team] [channel] [AlertManager URL] [setting=value...]", "Add an alert configuration")
+	configAdd.AddTextArgument("Team, channel, AlertManager URL and optional settings", "[team] [channel] [AlertManager URL] [setting=value...]", "")
+	config.AddCommand(configAdd)
+	configUpdate := model.NewAutocompleteData("update", "[AlertManager Config ID] [setting=value...]", "Change settings of an alert configuration")
+	configUpdate.AddTextArgument("Alert configuration and settings", "[AlertManager Config ID] [setting=value...]", "")
+	config.AddCommand(configUpdate)
+	configRemove := model.NewAutocompleteData("remove", "[AlertManager Config ID]", "Remove an alert configuration")
+	configRemove.AddTextArgument("The alert configuration to remove", "[AlertManager Config ID]", "")
+	config.AddCommand(configRemove)
+	config.AddCommand(model.NewAutocompleteData("list", "", "List the alert configurations"))
+	root.AddCommand(config)
+
+	setup := model.NewAutocompleteData("setup", "[AlertManager Config ID]", "Show the Alertmanager receiver configuration of an alert configuration")
+	setup.AddTextArgument("The alert configuration to set up", "[AlertManager Config ID]", "")
+	root.AddCommand(setup)
+
+	test := model.NewAutocompleteData("test", "[AlertManager Config ID] [firing|resolved] [label=value...] [--send]", "Post a test alert, or send it to Alertmanager with --send")
+	test.AddTextArgument("Alert configuration, status, labels and --send", "[AlertManager Config ID] [firing|resolved] [label=value...] [--send]", "")
+	root.AddCommand(test)
+
+	fire := model.NewAutocompleteData("fire", "[AlertManager Config ID]", "Fire an alert in Alertmanager")
+	fire.AddTextArgument("The alert configuration to fire the alert with", "[AlertManager Config ID]", "")
+	root.AddCommand(fire)
+
+	subscribe := model.NewAutocompleteData("subscribe", "[AlertManager Config ID] [matchers]", "Post the matching alerts of a configuration to this channel")
+	subscribe.AddTextArgument("Alert configuration and optional comma-separated matchers", "[AlertManager Config ID] [matchers]", "")
+	root.AddCommand(subscribe)
+
+	unsubscribe := model.NewAutocompleteData("unsubscribe", "[Subscription ID|AlertManager Config ID]", "Remove subscriptions of this channel")
+	unsubscribe.AddTextArgument("The subscription, or alert configuration, to remove", "[Subscription ID|AlertManager Config ID]", "")
+	root.AddCommand(unsubscribe)
+
+	root.AddCommand(model.NewAutocompleteData("subscriptions", "", "List the subscriptions of this channel"))
+
+	root.AddCommand(model.NewAutocompleteData("deadletters", "", "List the notifications that could not be posted"))
+
+	retry := model.NewAutocompleteData("retry", "[Dead Letter ID|all]", "Queue dead letters for delivery again")
+	retry.AddTextArgument("The ID of the dead letter to retry, or all", "[Dead Letter ID|all]", "")
+	root.AddCommand(retry)
+
 	help := model.NewAutocompleteData(actionHelp, "", "Display Slash Command help text")
 	root.AddCommand(help)
 
@@ -118,6 +179,26 @@ func (p *Plugin) executeCommand(args *model.CommandArgs) string {
 		msg, err = p.handleListSilences(args)
 	case "expire_silence":
 		msg, err = p.handleExpireSilence(args)
+	case "digest":
+		msg, err = p.handleDigest(args)
+	case "config":
+		msg, err = p.handleConfig(args)
+	case "setup":
+		msg, err = p.handleSetup(args)
+	case "test":
+		msg, err = p.handleTest(args)
+	case "fire":
+		msg, err = p.handleFire(args)
+	case "subscribe":
+		msg, err = p.handleSubscribe(args)
+	case "unsubscribe":
+		msg, err = p.handleUnsubscribe(args)
+	case "subscriptions":
+		msg, err = p.handleSubscriptions(args)
+	case "deadletters":
+		msg, err = p.handleDeadLetters(args)
+	case "retry":
+		msg, err = p.handleRetry(args)
 	case actionAbout:
 		msg, err = command.BuildInfo(Manifest)
 	case actionHelp:
@@ -172,7 +253,7 @@ func (p *Plugin) handleAlert(args *model.CommandArgs) (string, error) {
 		}
 
 		post := &model.Post{
-			ChannelId: p.AlertConfigIDChannelID[alertConfig.ID],
+			ChannelId: p.getAlertChannelID(alertConfig.ID),
 			UserId:    p.BotUserID,
 			RootId:    args.RootId,
 		}
@@ -216,7 +297,7 @@ func (p *Plugin) handleStatus(args *model.CommandArgs) (string, error) {
 		}
 
 		post := &model.Post{
-			ChannelId: p.AlertConfigIDChannelID[alertConfig.ID],
+			ChannelId: p.getAlertChannelID(alertConfig.ID),
 			UserId:    p.BotUserID,
 			RootId:    args.RootId,
 		}
@@ -273,7 +354,7 @@ func (p *Plugin) handleListSilences(args *model.CommandArgs) (string, error) {
 		pendingSilencesCount += len(attachments)
 
 		post := &model.Post{
-			ChannelId: p.AlertConfigIDChannelID[alertConfig.ID],
+			ChannelId: p.getAlertChannelID(alertConfig.ID),
 			UserId:    p.BotUserID,
 			RootId:    args.RootId,
 		}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const configsAPIPath = "/api/v1/configs"
+
+var errConfigNotFound = errors.New("alert configuration not found")
+
+// generateToken returns a random URL-safe token of 32 characters, like the tokens generated by
+// the System Console.
+func generateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// nextConfigID returns the ID following the largest numeric ID of configs, as the System Console
+// does.
+func nextConfigID(configs map[string]alertConfig) string {
+	next := 0
+	for id := range configs {
+		if n, err := strconv.Atoi(id); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	return strconv.Itoa(next)
+}
+
+// setAlertConfigField sets the field of config named name, case-insensitively, as the System
+// Console stores the settings with lowercase names.
+func setAlertConfigField(config *alertConfig, name, value string) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		if field.Name == "ID" {
+			return errors.New("the ID of an alert configuration cannot be changed")
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			v.Field(i).SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false: %w", name, err)
+			}
+			v.Field(i).SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a number: %w", name, err)
+			}
+			v.Field(i).SetInt(int64(n))
+		default:
+			return fmt.Errorf("setting %s is not supported", name)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown setting %s", name)
+}
+
+// pluginConfig returns the configuration in the format the System Console saves it, with the
+// lowercase setting names the admin console component uses.
+func (c *configuration) pluginConfig() (map[string]interface{}, error) {
+	alertConfigs := make(map[string]interface{}, len(c.AlertConfigs))
+	for id, config := range c.AlertConfigs {
+		b, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return nil, err
+		}
+
+		settings := make(map[string]interface{}, len(fields))
+		for name, value := range fields {
+			settings[strings.ToLower(name)] = value
+		}
+		alertConfigs[id] = settings
+	}
+
+	return map[string]interface{}{
+		"alertconfigs": alertConfigs,
+		"metricstoken": c.MetricsToken,
+	}, nil
+}
+
+// saveAlertConfigs applies fn to a copy of the stored alert configurations, validates the ones it
+// added or changed, and saves them to the plugin configuration, which triggers
+// OnConfigurationChange. The stored alert configurations are used rather than the active ones, so
+// the invalid alert configurations an admin is still fixing in the System Console are kept as
+// they are.
+func (p *Plugin) saveAlertConfigs(fn func(configs map[string]alertConfig) error) error {
+	stored := configuration{AlertConfigs: make(map[string]alertConfig)}
+	if err := p.API.LoadPluginConfiguration(&stored); err != nil {
+		return fmt.Errorf("failed to load the plugin configuration: %w", err)
+	}
+	for id, config := range stored.AlertConfigs {
+		config.ID = id
+		stored.AlertConfigs[id] = config
+	}
+
+	// Only the short tokens stored before their length was enforced are accepted.
+	updated := stored.Clone()
+	updated.legacyTokens = p.getConfiguration().legacyTokens
+	if err := fn(updated.AlertConfigs); err != nil {
+		return err
+	}
+
+	for _, id := range sortedConfigIDs(updated.AlertConfigs) {
+		if previous, ok := stored.AlertConfigs[id]; ok && previous == updated.AlertConfigs[id] {
+			continue
+		}
+
+		if err := updated.validateAlertConfig(id); err != nil {
+			return fmt.Errorf("alert config %s: %w", id, err)
+		}
+	}
+
+	pluginConfig, err := updated.pluginConfig()
+	if err != nil {
+		return fmt.Errorf("failed to encode the plugin configuration: %w", err)
+	}
+
+	if appErr := p.API.SavePluginConfig(pluginConfig); appErr != nil {
+		return fmt.Errorf("failed to save the plugin configuration: %w", appErr)
+	}
+
+	return nil
+}
+
+// addAlertConfig adds config with a new ID, generating its token if it has none.
+func (p *Plugin) addAlertConfig(config alertConfig) (alertConfig, error) {
+	if config.Token == "" {
+		token, err := generateToken()
+		if err != nil {
+			return config, fmt.Errorf("failed to generate a token: %w", err)
+		}
+		config.Token = token
+	}
+
+	err := p.saveAlertConfigs(func(configs map[string]alertConfig) error {
+		config.ID = nextConfigID(configs)
+		configs[config.ID] = config
+		return nil
+	})
+
+	return config, err
+}
+
+// updateAlertConfig applies fn to the alert configuration with the given ID.
+func (p *Plugin) updateAlertConfig(id string, fn func(config *alertConfig) error) (alertConfig, error) {
+	var updated alertConfig
+	err := p.saveAlertConfigs(func(configs map[string]alertConfig) error {
+		config, ok := configs[id]
+		if !ok {
+			return errConfigNotFound
+		}
+
+		if err := fn(&config); err != nil {
+			return err
+		}
+		config.ID = id
+
+		configs[id] = config
+		updated = config
+		return nil
+	})
+
+	return updated, err
+}
+
+func (p *Plugin) removeAlertConfig(id string) error {
+	return p.saveAlertConfigs(func(configs map[string]alertConfig) error {
+		if _, ok := configs[id]; !ok {
+			return errConfigNotFound
+		}
+
+		delete(configs, id)
+		return nil
+	})
+}
+
+func (p *Plugin) handleConfig(args *model.CommandArgs) (string, error) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system administrators can manage alert configurations.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	var parameters []string
+	if len(split) > 2 {
+		parameters = split[2:]
+	}
+
+	if len(parameters) == 0 {
+		return configHelpMsg, nil
+	}
+
+	switch parameters[0] {
+	case "add":
+		return p.handleConfigAdd(parameters[1:])
+	case "update":
+		return p.handleConfigUpdate(parameters[1:])
+	case "remove":
+		return p.handleConfigRemove(parameters[1:])
+	case "list":
+		return p.handleConfigList()
+	default:
+		return configHelpMsg, nil
+	}
+}
+
+const configHelpMsg = `run:
+	/alertmanager config add [team] [channel] [AlertManager URL] [setting=value...] - add an alert configuration, e.g. "myteam alerts http://alertmanager:9093 rendermode=compact"
+	/alertmanager config update [AlertManager Config ID] [setting=value...] - change settings of an alert configuration, e.g. "0 reminderafter=30"
+	/alertmanager config remove [AlertManager Config ID] - remove an alert configuration
+	/alertmanager config list - list the alert configurations
+	`
+
+// applySettings sets the setting=value parameters on config.
+func applySettings(config *alertConfig, parameters []string) error {
+	for _, parameter := range parameters {
+		name, value, ok := strings.Cut(parameter, "=")
+		if !ok {
+			return fmt.Errorf("setting %q must have the form setting=value", parameter)
+		}
+		if err := setAlertConfigField(config, name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) handleConfigAdd(parameters []string) (string, error) {
+	if len(parameters) < 3 {
+		return "Command requires a team, a channel and an AlertManager URL, e.g. `/alertmanager config add myteam alerts http://alertmanager:9093`", nil
+	}
+
+	config := alertConfig{
+		Team:            parameters[0],
+		Channel:         parameters[1],
+		AlertManagerURL: strings.TrimRight(parameters[2], "/"),
+	}
+	if err := applySettings(&config, parameters[3:]); err != nil {
+		return err.Error(), nil
+	}
+
+	config, err := p.addAlertConfig(config)
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	return fmt.Sprintf("Alert configuration %s added. Configure Alertmanager to send its notifications to:\n```\n%s\n```",
+		config.ID, p.webhookURL(config)), nil
+}
+
+func (p *Plugin) handleConfigUpdate(parameters []string) (string, error) {
+	if len(parameters) < 2 {
+		return "Command requires an AlertManager Config ID and settings, e.g. `/alertmanager config update 0 reminderafter=30`", nil
+	}
+
+	_, err := p.updateAlertConfig(parameters[0], func(config *alertConfig) error {
+		return applySettings(config, parameters[1:])
+	})
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	return fmt.Sprintf("Alert configuration %s updated.", parameters[0]), nil
+}
+
+func (p *Plugin) handleConfigRemove(parameters []string) (string, error) {
+	if len(parameters) != 1 {
+		return "Command requires 1 parameter: AlertManager Config ID", nil
+	}
+
+	if err := p.removeAlertConfig(parameters[0]); err != nil {
+		return err.Error(), nil
+	}
+
+	return fmt.Sprintf("Alert configuration %s removed.", parameters[0]), nil
+}
+
+func (p *Plugin) handleConfigList() (string, error) {
+	configuration := p.getConfiguration()
+	configs := make(map[string]alertConfig, len(configuration.AlertConfigs))
+	for id, config := range configuration.AlertConfigs {
+		configs[id] = config
+	}
+	// The invalid alert configs of the System Console are listed as they were saved.
+	for id, rejected := range configuration.rejectedAlertConfigs {
+		configs[id] = rejected.Config
+	}
+	if len(configs) == 0 {
+		return "No alert configurations.", nil
+	}
+
+	msg := "| ID | Team | Channel | AlertManager URL | Status |\n|---|---|---|---|---|\n"
+	for _, id := range sortedConfigIDs(configs) {
+		config := configs[id]
+		status := "OK"
+		if rejected, ok := configuration.rejectedAlertConfigs[id]; ok && rejected.PreviousActive {
+			status = ":warning: invalid, the previous version stays active: " + rejected.Error
+		} else if ok {
+			status = ":warning: invalid, not active: " + rejected.Error
+		} else if channelErr := p.getAlertChannelError(id); channelErr != "" {
+			status = ":warning: " + channelErr
+		}
+		msg += fmt.Sprintf("| %s | %s | %s | %s | %s |\n", id, config.Team, config.Channel, config.AlertManagerURL, strings.ReplaceAll(status, "\n", " "))
+	}
+
+	return msg, nil
+}
+
+// webhookURL returns the URL Alertmanager must send the notifications of config to.
+func (p *Plugin) webhookURL(config alertConfig) string {
+	return fmt.Sprintf("%s/plugins/%s/api/webhook?token=%s", strings.TrimRight(p.siteURL(), "/"), manifest.ID, config.Token)
+}
+
+// handleConfigsAPI serves the REST API managing the alert configurations to system admins:
+//
+//	GET    /api/v1/configs       list the alert configurations
+//	POST   /api/v1/configs       add an alert configuration
+//	GET    /api/v1/configs/{id}  get an alert configuration
+//	PUT    /api/v1/configs/{id}  replace an alert configuration
+//	DELETE /api/v1/configs/{id}  remove an alert configuration
+func (p *Plugin) handleConfigsAPI(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" || !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		writeAPIError(w, http.StatusForbidden, "only system administrators can manage alert configurations")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, configsAPIPath), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeAPIResponse(w, http.StatusOK, p.getConfiguration().AlertConfigs)
+	case id == "" && r.Method == http.MethodPost:
+		var config alertConfig
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBodySize)).Decode(&config); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode alert configuration: %v", err))
+			return
+		}
+		config, err := p.addAlertConfig(config)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAPIResponse(w, http.StatusCreated, config)
+	case id != "" && r.Method == http.MethodGet:
+		config, ok := p.getConfiguration().AlertConfigs[id]
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, errConfigNotFound.Error())
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, config)
+	case id != "" && r.Method == http.MethodPut:
+		var replacement alertConfig
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBodySize)).Decode(&replacement); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode alert configuration: %v", err))
+			return
+		}
+		config, err := p.updateAlertConfig(id, func(config *alertConfig) error {
+			if replacement.Token == "" {
+				replacement.Token = config.Token
+			}
+			*config = replacement
+			return nil
+		})
+		if err != nil {
+			writeAPIError(w, configsAPIErrorStatus(err), err.Error())
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, config)
+	case id != "" && r.Method == http.MethodDelete:
+		if err := p.removeAlertConfig(id); err != nil {
+			writeAPIError(w, configsAPIErrorStatus(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func configsAPIErrorStatus(err error) int {
+	if errors.Is(err, errConfigNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadRequest
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextConfigID(t *testing.T) {
+	assert.Equal(t, "0", nextConfigID(nil))
+	assert.Equal(t, "3", nextConfigID(map[string]alertConfig{"0": {}, "2": {}, "custom": {}}))
+}
+
+func TestApplySettings(t *testing.T) {
+	config := alertConfig{ID: "0"}
+	require.NoError(t, applySettings(&config, []string{"reminderafter=30", "RenderMode=compact", "labeldenylist=job,instance"}))
+	assert.Equal(t, 30, config.ReminderAfter)
+	assert.Equal(t, "compact", config.RenderMode)
+	assert.Equal(t, "job,instance", config.LabelDenyList)
+
+	require.NoError(t, applySettings(&config, []string{"disablechannelcreation=true"}))
+	assert.True(t, config.DisableChannelCreation)
+	assert.Error(t, applySettings(&config, []string{"disablechannelcreation=maybe"}))
+
+	assert.EqualError(t, applySettings(&config, []string{"reminderafter"}), `setting "reminderafter" must have the form setting=value`)
+	assert.EqualError(t, applySettings(&config, []string{"unknown=1"}), "unknown setting unknown")
+	assert.EqualError(t, applySettings(&config, []string{"id=1"}), "the ID of an alert configuration cannot be changed")
+	assert.Error(t, applySettings(&config, []string{"reminderafter=soon"}))
+}
+
+func TestPluginConfig(t *testing.T) {
+	c := &configuration{
+		AlertConfigs: map[string]alertConfig{
+			"0": {ID: "0", Token: "token", Team: "team", Channel: "alerts", AlertManagerURL: "http://alertmanager:9093", ReminderAfter: 30},
+		},
+		MetricsToken: "metrics",
+	}
+
+	pluginConfig, err := c.pluginConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "alerts", pluginConfig["alertconfigs"].(map[string]interface{})["0"].(map[string]interface{})["channel"])
+
+	// The saved configuration loads back as it was, like in OnConfigurationChange.
+	b, err := json.Marshal(pluginConfig)
+	require.NoError(t, err)
+	var loaded configuration
+	require.NoError(t, json.Unmarshal(b, &loaded))
+	assert.Equal(t, c, &loaded)
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, err := generateToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 32)
+	assert.NotContains(t, token, "/")
+}
+
+func TestSaveAlertConfigs(t *testing.T) {
+	// Alert config 1 is being fixed in the System Console, and is not active.
+	stored := `{"alertconfigs": {
+		"0": {"id": "0", "token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"},
+		"1": {"token": "vutsrqponmlkjihgfedcba9876543210", "team": "team", "channel": "db-alerts"}
+	}, "metricstoken": "metrics"}`
+
+	var saved configuration
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal([]byte(stored), args.Get(0)))
+	})
+	api.On("SavePluginConfig", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		b, err := json.Marshal(args.Get(0))
+		require.NoError(t, err)
+		saved = configuration{}
+		require.NoError(t, json.Unmarshal(b, &saved))
+	})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": validAlertConfig()}})
+
+	_, err := p.updateAlertConfig("0", func(config *alertConfig) error {
+		config.ReminderAfter = 30
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 30, saved.AlertConfigs["0"].ReminderAfter)
+	assert.Equal(t, "db-alerts", saved.AlertConfigs["1"].Channel)
+	assert.Equal(t, "metrics", saved.MetricsToken)
+
+	// Only the alert config changed is validated.
+	_, err = p.updateAlertConfig("1", func(config *alertConfig) error {
+		config.ReminderAfter = 30
+		return nil
+	})
+	assert.EqualError(t, err, "alert config 1: must set the AlertManager URL")
+
+	_, err = p.addAlertConfig(alertConfig{Token: "short", Team: "team", Channel: "infra-alerts", AlertManagerURL: "http://alertmanager:9093"})
+	assert.EqualError(t, err, "alert config 2: token must be at least 16 characters")
+
+	_, err = p.addAlertConfig(alertConfig{Token: "vutsrqponmlkjihgfedcba9876543210", Team: "team", Channel: "infra-alerts", AlertManagerURL: "http://alertmanager:9093"})
+	assert.EqualError(t, err, "alert config 2: same token as alert config 1")
+
+	require.NoError(t, p.removeAlertConfig("0"))
+	assert.NotContains(t, saved.AlertConfigs, "0")
+	assert.Contains(t, saved.AlertConfigs, "1")
+	api.AssertNumberOfCalls(t, "SavePluginConfig", 2)
+}
+
+func TestHandleConfigList(t *testing.T) {
+	p, _, _ := newTestPlugin(t)
+	rejected := validAlertConfig()
+	rejected.ID = "1"
+	rejected.Channel = "db-alerts"
+	rejected.AlertManagerURL = ""
+	p.setConfiguration(&configuration{
+		AlertConfigs: map[string]alertConfig{"0": validAlertConfig()},
+		rejectedAlertConfigs: map[string]rejectedAlertConfig{
+			"1": {Config: rejected, Error: "must set the AlertManager URL"},
+		},
+	})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+
+	msg, err := p.handleConfigList()
+	require.NoError(t, err)
+	assert.Contains(t, msg, "| 0 | team | alerts | http://alertmanager:9093 | OK |")
+	assert.Contains(t, msg, "| 1 | team | db-alerts |  | :warning: invalid, not active: must set the AlertManager URL |")
+}
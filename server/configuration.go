@@ -1,10 +1,18 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -15,45 +23,320 @@ import (
 // configuration can change at any time, access to the configuration must be synchronized. The
 // strategy used in this plugin is to guard a pointer to the configuration, and clone the entire
 // struct whenever it changes. You may replace this with whatever strategy you choose.
-//
-// If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
-// copy appropriate for your types.
 type configuration struct {
 	AlertConfigs map[string]alertConfig
+
+	// MetricsToken authenticates the Prometheus scrapes of /plugins/alertmanager/metrics. The
+	// metrics are not served while it is empty.
+	MetricsToken string
+
+	// legacyTokens holds the hashes of the short tokens stored before minTokenLength was
+	// enforced, which keep working.
+	legacyTokens map[string]bool
+	// rejectedAlertConfigs holds the invalid alert configs of the Mattermost server
+	// configuration, which are not active or keep their previous version, keyed by ID.
+	rejectedAlertConfigs map[string]rejectedAlertConfig
+}
+
+// rejectedAlertConfig is an invalid alert config of the Mattermost server configuration.
+type rejectedAlertConfig struct {
+	Config alertConfig
+	Error  string
+	// PreviousActive reports whether the previous version of the alert config stays active.
+	PreviousActive bool
 }
 
 type alertConfig struct {
-	ID              string
-	Token           string
+	ID    string
+	Token string
+	// Channel is the name or the ID of the channel of Team the alerts are posted to.
 	Channel         string
 	Team            string
 	AlertManagerURL string
+
+	// ChannelType is the type of the channel created when Channel does not exist: "public" (the
+	// default) or "private". DisableChannelCreation reports a missing channel instead.
+	ChannelType            string
+	DisableChannelCreation bool
+
+	// ReminderAfter is the number of minutes a firing, unacknowledged alert group waits before
+	// the first reminder is posted in its thread. Zero disables reminders.
+	ReminderAfter int
+	// ReminderMentions is a comma-separated list of mentions, escalated one entry per reminder.
+	ReminderMentions string
+
+	// SilenceExpiryWarning is the number of minutes before an active silence ends that a warning
+	// is posted. Zero disables warnings.
+	SilenceExpiryWarning int
+
+	// PollInterval is the number of seconds between polls of the Alertmanager API, for
+	// Alertmanagers that cannot send webhooks to Mattermost. Zero disables polling.
+	PollInterval int
+
+	// HealthCheckFailures is the number of consecutive failed status checks after which the
+	// Alertmanager is reported unreachable. Zero disables health checks.
+	HealthCheckFailures int
+	// HealthCheckChannel is the name of a channel in Team receiving health reports instead of
+	// Channel.
+	HealthCheckChannel string
+
+	// HeartbeatMatcher selects an always-firing alert, such as `alertname="Watchdog"`, that is
+	// not posted but proves the alerting pipeline works.
+	HeartbeatMatcher string
+	// HeartbeatTimeout is the number of minutes without heartbeat after which the pipeline is
+	// reported broken. Zero disables the check.
+	HeartbeatTimeout int
+
+	// FlapThreshold is the number of firing/resolved transitions within FlapWindow minutes after
+	// which an alert is flapping and its transitions are collapsed into a single post, until it
+	// is stable for FlapStablePeriod minutes. Zero disables flapping detection.
+	FlapThreshold    int
+	FlapWindow       int
+	FlapStablePeriod int
+
+	// RateLimit is the number of webhook posts per minute allowed in the channel, with bursts of
+	// up to RateLimitBurst posts. Notifications over the limit are aggregated into one post. Zero
+	// disables rate limiting.
+	RateLimit      int
+	RateLimitBurst int
+
+	// RenderMode is how webhook posts render their alerts: "detailed" (the default), "compact"
+	// or "summary". LabelAllowList and LabelDenyList are comma-separated label names limiting
+	// the labels rendered.
+	RenderMode     string
+	LabelAllowList string
+	LabelDenyList  string
+
+	// LinkAnnotations is a comma-separated list of annotations holding URLs, rendered as links
+	// next to the runbook_url and dashboard_url annotations.
+	LinkAnnotations string
+
+	// Timezone is the IANA timezone, such as Europe/Berlin, and TimeFormat the Go time layout
+	// of the times rendered in posts. They default to the timezone of the alert and RFC1123.
+	Timezone   string
+	TimeFormat string
+
+	// DedupWindow is the number of seconds during which a notification identical to one already
+	// received, such as the copy sent by the other replica of an Alertmanager HA pair, is
+	// dropped. Zero disables deduplication.
+	DedupWindow int
+
+	// IncidentMatcher selects the alert groups, such as `severity="critical"`, for which an
+	// incident channel named from IncidentChannelTemplate is opened, with the comma-separated
+	// IncidentResponders as members. The channel is archived IncidentArchiveAfter minutes after
+	// the group resolves, zero keeping it. An empty matcher disables incident channels.
+	IncidentMatcher         string
+	IncidentChannelTemplate string
+	IncidentResponders      string
+	IncidentArchiveAfter    int
+}
+
+// minTokenLength is the minimum length of the tokens authenticating Alertmanager, which are 32
+// characters when generated. Shorter tokens stored before it was enforced keep working, with a
+// warning, until they are rotated, but cannot be set anymore.
+const minTokenLength = 16
+
+// legacyTokensKey stores the hashes of the short tokens stored before minTokenLength was
+// enforced.
+const legacyTokensKey = "legacy_tokens"
+
+// tokenHash returns the hash of a token, which is stored rather than the token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shortTokenHashes returns the hashes of the short tokens of the alert configs.
+func shortTokenHashes(configs map[string]alertConfig) map[string]bool {
+	hashes := make(map[string]bool)
+	for _, ac := range configs {
+		if ac.hasShortToken() {
+			hashes[tokenHash(ac.Token)] = true
+		}
+	}
+
+	return hashes
+}
+
+// hasShortToken reports whether the token of the alert config is shorter than minTokenLength.
+func (ac *alertConfig) hasShortToken() bool {
+	return ac.Token != "" && len(ac.Token) < minTokenLength
 }
 
+// IsValid returns the errors of all the invalid settings of the alert config.
 func (ac *alertConfig) IsValid() error {
+	var errs []error
+
 	if ac.Team == "" {
-		return errors.New("must set a Team")
+		errs = append(errs, errors.New("must set a Team"))
 	}
 
 	if ac.Channel == "" {
-		return errors.New("must set a Channel")
+		errs = append(errs, errors.New("must set a Channel"))
 	}
 
 	if ac.Token == "" {
-		return errors.New("must set a Token")
+		errs = append(errs, errors.New("must set a Token"))
 	}
 
 	if ac.AlertManagerURL == "" {
-		return errors.New("must set the AlertManager URL")
+		errs = append(errs, errors.New("must set the AlertManager URL"))
+	} else if u, err := url.Parse(ac.AlertManagerURL); err != nil {
+		errs = append(errs, fmt.Errorf("invalid AlertManager URL: %w", err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid AlertManager URL %q, must be an http or https URL", ac.AlertManagerURL))
+	}
+
+	if ac.ReminderAfter < 0 {
+		errs = append(errs, errors.New("reminder interval cannot be negative"))
+	}
+
+	if ac.SilenceExpiryWarning < 0 {
+		errs = append(errs, errors.New("silence expiry warning window cannot be negative"))
+	}
+
+	if ac.PollInterval < 0 {
+		errs = append(errs, errors.New("poll interval cannot be negative"))
+	}
+
+	if ac.HealthCheckFailures < 0 {
+		errs = append(errs, errors.New("health check failure threshold cannot be negative"))
+	}
+
+	if ac.HeartbeatMatcher != "" {
+		if _, err := parseMatchers(ac.HeartbeatMatcher); err != nil {
+			errs = append(errs, fmt.Errorf("invalid heartbeat matcher: %w", err))
+		}
+	}
+
+	if ac.HeartbeatTimeout < 0 {
+		errs = append(errs, errors.New("heartbeat timeout cannot be negative"))
+	}
+
+	if ac.FlapThreshold < 0 || ac.FlapWindow < 0 || ac.FlapStablePeriod < 0 {
+		errs = append(errs, errors.New("flapping settings cannot be negative"))
+	}
+
+	if ac.RateLimit < 0 || ac.RateLimitBurst < 0 {
+		errs = append(errs, errors.New("rate limit settings cannot be negative"))
+	}
+
+	if ac.DedupWindow < 0 {
+		errs = append(errs, errors.New("deduplication window cannot be negative"))
+	}
+
+	if ac.IncidentMatcher != "" {
+		if _, err := parseMatchers(ac.IncidentMatcher); err != nil {
+			errs = append(errs, fmt.Errorf("invalid incident matcher: %w", err))
+		}
+	}
+
+	if ac.IncidentArchiveAfter < 0 {
+		errs = append(errs, errors.New("incident archive delay cannot be negative"))
+	}
+
+	if ac.Timezone != "" {
+		if _, err := time.LoadLocation(ac.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid timezone: %w", err))
+		}
+	}
+
+	switch ac.ChannelType {
+	case "", channelTypePublic, channelTypePrivate:
+	default:
+		errs = append(errs, fmt.Errorf("unknown channel type %q", ac.ChannelType))
+	}
+
+	switch ac.RenderMode {
+	case "", renderModeDetailed, renderModeCompact, renderModeSummary:
+	default:
+		errs = append(errs, fmt.Errorf("unknown render mode %q", ac.RenderMode))
+	}
+
+	return errors.Join(errs...)
+}
+
+// IsValid returns the errors of all the alert configs, including the tokens used by several of
+// them. The channels used by several alert configs are only known once they are resolved, see
+// updateAlertChannels.
+func (c *configuration) IsValid() error {
+	configErrors := c.alertConfigErrors()
+
+	var errs []error
+	for _, id := range sortedConfigIDs(c.AlertConfigs) {
+		if err, ok := configErrors[id]; ok {
+			errs = append(errs, fmt.Errorf("alert config %s: %w", id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// alertConfigErrors returns the errors of the invalid alert configs, keyed by ID. Of the alert
+// configs sharing a token, the first one by ID is valid.
+func (c *configuration) alertConfigErrors() map[string]error {
+	configErrors := make(map[string]error)
+	tokens := make(map[string]string)
+	for _, id := range sortedConfigIDs(c.AlertConfigs) {
+		ac := c.AlertConfigs[id]
+		errs := []error{ac.IsValid(), c.tokenError(ac)}
+
+		if ac.Token != "" {
+			if other, ok := tokens[ac.Token]; ok {
+				errs = append(errs, fmt.Errorf("same token as alert config %s", other))
+			} else {
+				tokens[ac.Token] = id
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			configErrors[id] = err
+		}
+	}
+
+	return configErrors
+}
+
+// validateAlertConfig returns the errors of the alert config with the given ID, including a token
+// used by any other alert config.
+func (c *configuration) validateAlertConfig(id string) error {
+	ac := c.AlertConfigs[id]
+	errs := []error{ac.IsValid(), c.tokenError(ac)}
+
+	for _, otherID := range sortedConfigIDs(c.AlertConfigs) {
+		if otherID != id && ac.Token != "" && c.AlertConfigs[otherID].Token == ac.Token {
+			errs = append(errs, fmt.Errorf("same token as alert config %s", otherID))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// tokenError returns an error if the token of the alert config is shorter than minTokenLength,
+// unless it was stored before the length was enforced.
+func (c *configuration) tokenError(ac alertConfig) error {
+	if ac.hasShortToken() && !c.legacyTokens[tokenHash(ac.Token)] {
+		return fmt.Errorf("token must be at least %d characters", minTokenLength)
 	}
 
 	return nil
 }
 
-// Clone shallow copies the configuration. Your implementation may require a deep copy if
-// your configuration has reference types.
+// sortedConfigIDs returns the IDs of the alert configs in order.
+func sortedConfigIDs(configs map[string]alertConfig) []string {
+	ids := make([]string, 0, len(configs))
+	for id := range configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Clone deep copies the configuration.
 func (c *configuration) Clone() *configuration {
-	var clone configuration
+	clone := *c
+	clone.AlertConfigs = make(map[string]alertConfig, len(c.AlertConfigs))
 	for k, v := range c.AlertConfigs {
 		clone.AlertConfigs[k] = v
 	}
@@ -103,15 +386,19 @@ func (p *Plugin) setConfiguration(configuration *configuration) {
 	p.configuration = configuration
 }
 
-// OnConfigurationChange is invoked when configuration changes may have been made.
-func (p *Plugin) OnConfigurationChange() error {
+// loadConfiguration loads the configuration from the Mattermost server configuration, and
+// validates it. An invalid alert config keeps its previous version if it had a valid one, and is
+// left out otherwise, so the valid alert configs stay active. The configuration is returned with
+// the errors of the invalid alert configs, which it records as rejected.
+func (p *Plugin) loadConfiguration(previous *configuration) (*configuration, error) {
 	var configurationInstance = configuration{
-		AlertConfigs: make(map[string]alertConfig),
+		AlertConfigs:         make(map[string]alertConfig),
+		rejectedAlertConfigs: make(map[string]rejectedAlertConfig),
 	}
 
 	// Load the public configuration fields from the Mattermost server configuration.
 	if err := p.API.LoadPluginConfiguration(&configurationInstance); err != nil {
-		return fmt.Errorf("failed to load plugin configuration: %w", err)
+		return nil, fmt.Errorf("failed to load plugin configuration: %w", err)
 	}
 
 	for id, alertConfigInstance := range configurationInstance.AlertConfigs {
@@ -120,7 +407,105 @@ func (p *Plugin) OnConfigurationChange() error {
 		configurationInstance.AlertConfigs[id] = alertConfigInstance
 	}
 
-	p.setConfiguration(&configurationInstance)
+	legacyTokens, err := p.loadLegacyTokens(configurationInstance.AlertConfigs)
+	if err != nil {
+		p.API.LogError("Failed to load the tokens stored before their length was enforced, accepting the short tokens", "error", err.Error())
+		legacyTokens = shortTokenHashes(configurationInstance.AlertConfigs)
+	}
+	configurationInstance.legacyTokens = legacyTokens
+
+	err = configurationInstance.IsValid()
+	if err != nil {
+		for id, configErr := range configurationInstance.alertConfigErrors() {
+			previousConfig, ok := previous.AlertConfigs[id]
+			configurationInstance.rejectedAlertConfigs[id] = rejectedAlertConfig{
+				Config:         configurationInstance.AlertConfigs[id],
+				Error:          configErr.Error(),
+				PreviousActive: ok,
+			}
+			if ok {
+				configurationInstance.AlertConfigs[id] = previousConfig
+			} else {
+				delete(configurationInstance.AlertConfigs, id)
+			}
+		}
+
+		// A previous version may conflict with the other alert configs.
+		for id, configErr := range configurationInstance.alertConfigErrors() {
+			rejected, ok := configurationInstance.rejectedAlertConfigs[id]
+			if !ok {
+				rejected = rejectedAlertConfig{Config: configurationInstance.AlertConfigs[id], Error: configErr.Error()}
+			}
+			rejected.PreviousActive = false
+			configurationInstance.rejectedAlertConfigs[id] = rejected
+			delete(configurationInstance.AlertConfigs, id)
+		}
+
+		err = fmt.Errorf("invalid plugin configuration: %w", err)
+	}
+
+	for _, id := range sortedConfigIDs(configurationInstance.AlertConfigs) {
+		if alertConfigInstance := configurationInstance.AlertConfigs[id]; alertConfigInstance.hasShortToken() {
+			p.API.LogWarn(fmt.Sprintf("The token of alert config %s is shorter than %d characters, rotate it with /alertmanager setup %s", id, minTokenLength, id))
+		}
+	}
+
+	return &configurationInstance, err
+}
+
+// loadLegacyTokens returns the hashes of the short tokens stored before minTokenLength was
+// enforced, which are the short tokens of the first configuration this version loads.
+func (p *Plugin) loadLegacyTokens(configs map[string]alertConfig) (map[string]bool, error) {
+	// The configuration is loaded before the plugin is activated, which sets p.client.
+	kv := pluginapi.NewClient(p.API, p.Driver).KV
+
+	var legacyTokens map[string]bool
+	err := kv.SetAtomicWithRetries(legacyTokensKey, func(oldValue []byte) (interface{}, error) {
+		if oldValue != nil {
+			legacyTokens = nil
+			if err := json.Unmarshal(oldValue, &legacyTokens); err != nil {
+				return nil, err
+			}
+			return nil, errKVUnchanged
+		}
+
+		legacyTokens = shortTokenHashes(configs)
+		return legacyTokens, nil
+	})
+	if err != nil && !errors.Is(err, errKVUnchanged) {
+		return nil, err
+	}
+
+	return legacyTokens, nil
+}
+
+// OnConfigurationChange is invoked when configuration changes may have been made. The valid
+// alert configs are activated, and the invalid ones keep their previous version if they had one.
+// Their errors are returned, and listed by /alertmanager config list.
+func (p *Plugin) OnConfigurationChange() error {
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+
+	previous := p.getConfiguration()
+	configuration, err := p.loadConfiguration(previous)
+	if configuration == nil {
+		return err
+	}
+	if err != nil {
+		// The server does not log the errors of the configuration loaded before activating
+		// the plugin.
+		p.API.LogError("Some alert configs are invalid and were not activated", "error", err.Error())
+	}
+
+	p.setConfiguration(configuration)
+
+	// The server loads the configuration before activating the plugin, which ensures the
+	// channels of the first configuration.
+	if p.client == nil {
+		return err
+	}
+
+	p.updateAlertChannels(previous, configuration)
 
-	return p.OnActivate()
+	return err
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func validAlertConfig() alertConfig {
+	return alertConfig{
+		ID:              "0",
+		Token:           "0123456789abcdefghijklmnopqrstuv",
+		Team:            "team",
+		Channel:         "alerts",
+		AlertManagerURL: "http://alertmanager:9093",
+	}
+}
+
+func TestClone(t *testing.T) {
+	c := &configuration{
+		AlertConfigs: map[string]alertConfig{"0": validAlertConfig()},
+		MetricsToken: "metrics",
+	}
+
+	clone := c.Clone()
+	assert.Equal(t, c, clone)
+
+	clone.AlertConfigs["1"] = validAlertConfig()
+	delete(clone.AlertConfigs, "0")
+	assert.Len(t, c.AlertConfigs, 1)
+	assert.Contains(t, c.AlertConfigs, "0")
+
+	assert.NotPanics(t, func() { (&configuration{}).Clone() })
+}
+
+func TestAlertConfigIsValid(t *testing.T) {
+	config := validAlertConfig()
+	assert.NoError(t, config.IsValid())
+
+	config.Token = "short"
+	assert.NoError(t, config.IsValid())
+	assert.True(t, config.hasShortToken())
+
+	config.Token = ""
+	config.AlertManagerURL = "alertmanager:9093"
+	config.ReminderAfter = -1
+	config.RenderMode = "fancy"
+	err := config.IsValid()
+	require.Error(t, err)
+	assert.False(t, config.hasShortToken())
+	assert.Contains(t, err.Error(), "must set a Token")
+	assert.Contains(t, err.Error(), "invalid AlertManager URL")
+	assert.Contains(t, err.Error(), "reminder interval cannot be negative")
+	assert.Contains(t, err.Error(), `unknown render mode "fancy"`)
+
+	config = validAlertConfig()
+	config.AlertManagerURL = "https://"
+	assert.Error(t, config.IsValid())
+}
+
+func TestConfigurationIsValid(t *testing.T) {
+	first := validAlertConfig()
+	second := validAlertConfig()
+	second.ID = "1"
+	second.Channel = "other-alerts"
+	second.Token = "vutsrqponmlkjihgfedcba9876543210"
+
+	c := &configuration{AlertConfigs: map[string]alertConfig{"0": first, "1": second}}
+	assert.NoError(t, c.IsValid())
+
+	// The channels are compared once resolved.
+	second.Channel = "Alerts"
+	c.AlertConfigs["1"] = second
+	assert.NoError(t, c.IsValid())
+
+	second.Token = first.Token
+	c.AlertConfigs["1"] = second
+	err := c.IsValid()
+	require.EqualError(t, err, "alert config 1: same token as alert config 0")
+	configErrors := c.alertConfigErrors()
+	assert.Len(t, configErrors, 1)
+	assert.Contains(t, configErrors, "1")
+
+	// Short tokens are only accepted if they were stored before their length was enforced.
+	second.Token = "short"
+	c.AlertConfigs["1"] = second
+	require.EqualError(t, c.IsValid(), "alert config 1: token must be at least 16 characters")
+	c.legacyTokens = map[string]bool{tokenHash("short"): true}
+	assert.NoError(t, c.IsValid())
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	newLoader := func(t *testing.T) (func(pluginConfig string, previous *configuration) (*configuration, error), *testKVStore) {
+		var pluginConfig string
+		api := &plugintest.API{}
+		api.On("LoadPluginConfiguration", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal([]byte(pluginConfig), args.Get(0)))
+		})
+		mockLogs(api)
+		store := mockKVStore(api)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+		return func(config string, previous *configuration) (*configuration, error) {
+			pluginConfig = config
+			return p.loadConfiguration(previous)
+		}, store
+	}
+	load := func(t *testing.T, pluginConfig string, previous *configuration) (*configuration, error) {
+		load, _ := newLoader(t)
+		return load(pluginConfig, previous)
+	}
+
+	c, err := load(t, `{
+		"alertconfigs": {
+			"0": {"token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093/"}
+		},
+		"metricstoken": "metrics"
+	}`, &configuration{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]alertConfig{"0": validAlertConfig()}, c.AlertConfigs)
+	assert.Equal(t, "metrics", c.MetricsToken)
+	assert.Empty(t, c.rejectedAlertConfigs)
+
+	c, err = load(t, `{}`, &configuration{})
+	require.NoError(t, err)
+	assert.Empty(t, c.AlertConfigs)
+
+	// The valid alert configs are active, and the invalid ones keep their previous version.
+	previous := validAlertConfig()
+	previous.ID = "1"
+	previous.Token = "vutsrqponmlkjihgfedcba9876543210"
+	c, err = load(t, `{"alertconfigs": {
+		"0": {"token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"},
+		"1": {"token": "vutsrqponmlkjihgfedcba9876543210", "team": "team", "channel": "alerts", "alertmanagerurl": "alertmanager:9093"},
+		"2": {"token": "fedcba9876543210vutsrqponmlkjihg", "alertmanagerurl": "http://alertmanager:9093"}
+	}}`, &configuration{AlertConfigs: map[string]alertConfig{"1": previous}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "alert config 1: invalid AlertManager URL")
+	assert.Contains(t, err.Error(), "must set a Team")
+	assert.Contains(t, err.Error(), "must set a Channel")
+	assert.Equal(t, map[string]alertConfig{"0": validAlertConfig(), "1": previous}, c.AlertConfigs)
+
+	// The rejected alert configs are recorded to be listed.
+	require.Len(t, c.rejectedAlertConfigs, 2)
+	assert.True(t, c.rejectedAlertConfigs["1"].PreviousActive)
+	assert.Equal(t, "alertmanager:9093", c.rejectedAlertConfigs["1"].Config.AlertManagerURL)
+	assert.False(t, c.rejectedAlertConfigs["2"].PreviousActive)
+	assert.Contains(t, c.rejectedAlertConfigs["2"].Error, "must set a Team")
+
+	// A previous version conflicting with the other alert configs is left out.
+	previous.Token = validAlertConfig().Token
+	c, err = load(t, `{"alertconfigs": {
+		"0": {"token": "0123456789abcdefghijklmnopqrstuv", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"},
+		"1": {"token": "vutsrqponmlkjihgfedcba9876543210", "team": "team", "channel": "alerts"}
+	}}`, &configuration{AlertConfigs: map[string]alertConfig{"1": previous}})
+	require.Error(t, err)
+	assert.Equal(t, map[string]alertConfig{"0": validAlertConfig()}, c.AlertConfigs)
+	assert.False(t, c.rejectedAlertConfigs["1"].PreviousActive)
+
+	// The short tokens stored before their length was enforced keep working until they are
+	// rotated, but new ones are rejected.
+	loadStored, store := newLoader(t)
+	c, err = loadStored(`{"alertconfigs": {
+		"0": {"token": "short", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"}
+	}}`, &configuration{})
+	require.NoError(t, err)
+	assert.Equal(t, "short", c.AlertConfigs["0"].Token)
+	assert.NotContains(t, string(store.get(legacyTokensKey)), "short")
+
+	c, err = loadStored(`{"alertconfigs": {
+		"0": {"token": "short", "team": "team", "channel": "alerts", "alertmanagerurl": "http://alertmanager:9093"},
+		"1": {"token": "weak", "team": "team", "channel": "db-alerts", "alertmanagerurl": "http://alertmanager:9093"}
+	}}`, c)
+	require.EqualError(t, err, "invalid plugin configuration: alert config 1: token must be at least 16 characters")
+	assert.Contains(t, c.AlertConfigs, "0")
+	assert.NotContains(t, c.AlertConfigs, "1")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+const dedupKeyPrefix = "dedup_"
+
+// dedupKey returns the KV key identifying a notification: its group key, status and the identity
+// and times of its alerts. Replicas of an Alertmanager HA pair send the same notification with
+// the same key.
+func dedupKey(configID string, message webhook.Message) string {
+	alerts := make([]string, 0, len(message.Alerts))
+	for _, alert := range message.Alerts {
+		// Alerts are identified by their labels, as some senders leave the fingerprint empty.
+		alerts = append(alerts, fmt.Sprintf("%s|%s|%d|%d", alert.Labels.SortedPairs(), alert.Status,
+			alert.StartsAt.UnixNano(), alert.EndsAt.UnixNano()))
+	}
+	sort.Strings(alerts)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", configID, message.GroupKey, message.Status)
+	for _, alert := range alerts {
+		fmt.Fprintln(hash, alert)
+	}
+
+	return fmt.Sprintf("%s%s_%s", dedupKeyPrefix, configID, hex.EncodeToString(hash.Sum(nil)[:16]))
+}
+
+// isDuplicateWebhook reports whether the same notification was already received within the
+// DedupWindow of the config. The first notification is recorded in the KV store, so duplicates
+// are detected across the nodes of a cluster.
+func (p *Plugin) isDuplicateWebhook(config alertConfig, message webhook.Message) bool {
+	if config.DedupWindow <= 0 {
+		return false
+	}
+
+	key := dedupKey(config.ID, message)
+	window := time.Duration(config.DedupWindow) * time.Second
+	saved, err := p.client.KV.Set(key, time.Now(), pluginapi.SetAtomic(nil), pluginapi.SetExpiry(window))
+	if err != nil {
+		// Posting a duplicate is better than dropping a notification.
+		p.API.LogWarn("Failed to record notification for deduplication", "config", config.ID, "error", err.Error())
+		return false
+	}
+
+	return !saved
+}
+
+// forgetWebhook removes the record of a notification made by isDuplicateWebhook, so that it is
+// accepted again when Alertmanager retries it.
+func (p *Plugin) forgetWebhook(config alertConfig, message webhook.Message) {
+	if config.DedupWindow <= 0 {
+		return
+	}
+
+	key := dedupKey(config.ID, message)
+	if err := p.client.KV.Delete(key); err != nil {
+		p.API.LogError("Failed to forget notification for deduplication", "config", config.ID, "error", err.Error())
+	}
+}
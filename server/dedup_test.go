@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupKey(t *testing.T) {
+	startsAt := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	db1 := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "instance": "db-1"}, StartsAt: startsAt, Fingerprint: "a1"}
+	db2 := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "instance": "db-2"}, StartsAt: startsAt, Fingerprint: "a2"}
+	message := func(status string, alerts ...template.Alert) webhook.Message {
+		return webhook.Message{GroupKey: "{}:{alertname=\"DiskFull\"}", Data: &template.Data{Status: status, Alerts: alerts}}
+	}
+
+	key := dedupKey("0", message("firing", db1, db2))
+	assert.Len(t, key, len(dedupKeyPrefix)+len("0_")+32)
+	assert.Equal(t, key, dedupKey("0", message("firing", db2, db1)), "alert order must not matter")
+	assert.NotEqual(t, key, dedupKey("1", message("firing", db1, db2)))
+	assert.NotEqual(t, key, dedupKey("0", message("firing", db1)))
+
+	resolved := db2
+	resolved.Status = "resolved"
+	resolved.EndsAt = startsAt.Add(time.Hour)
+	assert.NotEqual(t, key, dedupKey("0", message("firing", db1, resolved)))
+}
+
+func TestHandleWebhookDedupEnqueueFailure(t *testing.T) {
+	p, _, store := newTestPlugin(t)
+	var failEnqueue atomic.Bool
+	store.failSet = func(key string) bool {
+		return failEnqueue.Load() && strings.HasPrefix(key, deliveryKeyPrefix)
+	}
+	// The config is not active, so accepted notifications end up as dead letters.
+	config := alertConfig{ID: "0", DedupWindow: 60}
+
+	alert := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: time.Now().Add(-time.Minute)}
+	message := newWebhookMessage("mattermost", "{}:{alertname=\"DiskFull\"}", "http://alertmanager:9093", template.KV{"alertname": "DiskFull"}, template.Alerts{alert})
+	body, err := json.Marshal(message)
+	require.NoError(t, err)
+	send := func() int {
+		w := httptest.NewRecorder()
+		p.handleWebhook(w, httptest.NewRequest(http.MethodPost, "/api/webhook", bytes.NewReader(body)), config)
+		return w.Code
+	}
+
+	failEnqueue.Store(true)
+	assert.Equal(t, http.StatusInternalServerError, send())
+	assert.Nil(t, store.get(dedupKey("0", message)), "a notification failing to enqueue must not be recorded")
+
+	// The retry of Alertmanager is accepted, and later copies are dropped as duplicates.
+	failEnqueue.Store(false)
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusOK, send())
+
+	assert.Eventually(t, func() bool {
+		keys, err := p.listKeys(deadLetterKeyPrefix)
+		return err == nil && len(keys) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	prommodel "github.com/prometheus/common/model"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	deliveryJobKey      = "deliveries"
+	deliveryJobInterval = 10 * time.Second
+	deliveryKeyPrefix   = "delivery_"
+	deadLetterKeyPrefix = "deadletter_"
+	deliveryLease       = time.Minute
+	// deliveryBufferedLease is how long a delivery stays claimed while the rate limiter of this
+	// node buffers its notification, which takes at most a minute. Once it expires, the delivery
+	// is retried by another node, e.g. if this one stopped.
+	deliveryBufferedLease  = 5 * time.Minute
+	deliveryMaxAttempts    = 10
+	deliveryInitialBackoff = 10 * time.Second
+	deliveryMaxBackoff     = time.Hour
+	// deadLetterRetention is how long a dead letter is kept for retrying it, which bounds the
+	// dead letters piling up while the posts fail, e.g. during an outage.
+	deadLetterRetention = 7 * 24 * time.Hour
+)
+
+// delivery is a notification queued for posting. It is stored under delivery_<ID> until it is
+// posted, and moved to deadletter_<ID> for deadLetterRetention once it failed
+// deliveryMaxAttempts times.
+type delivery struct {
+	ID           string
+	ConfigID     string
+	Message      webhook.Message
+	CreatedAt    time.Time
+	Attempts     int
+	NextAttempt  time.Time
+	ClaimedUntil time.Time
+	LastError    string
+	// Filtered reports whether Message went through the heartbeat and flapping filters, which
+	// record the notifications they see and so run once per delivery, not on its retries.
+	Filtered bool
+	// Posted are the channels the notification was posted to by earlier attempts, which retries
+	// skip.
+	Posted []string
+}
+
+// errDeliveryBuffered is returned by the posts of a delivery buffered by the rate limiter. The
+// delivery stays queued until the buffered notifications are posted.
+var errDeliveryBuffered = errors.New("notification buffered by the rate limiter")
+
+// deliveryErrors collects the errors of the posts of a delivery to several channels.
+type deliveryErrors struct {
+	errs     []error
+	buffered bool
+}
+
+func (e *deliveryErrors) add(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errDeliveryBuffered):
+		e.buffered = true
+	default:
+		e.errs = append(e.errs, err)
+	}
+}
+
+// err returns the errors of the failed posts, or errDeliveryBuffered if posts were only
+// buffered. A retry of the failed posts retries the buffered ones too, which the rate limiter
+// does not buffer twice.
+func (e *deliveryErrors) err() error {
+	if len(e.errs) > 0 {
+		return errors.Join(e.errs...)
+	}
+	if e.buffered {
+		return errDeliveryBuffered
+	}
+
+	return nil
+}
+
+// posted reports whether an earlier attempt posted the notification to a channel.
+func (d *delivery) posted(channelID string) bool {
+	for _, posted := range d.Posted {
+		if posted == channelID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markPosted records on the queued delivery that the notification was posted to a channel.
+func (p *Plugin) markPosted(d *delivery, channelID string) error {
+	d.Posted = append(d.Posted, channelID)
+
+	_, err := p.updateDelivery(d.ID, func(stored *delivery) {
+		if !stored.posted(channelID) {
+			stored.Posted = append(stored.Posted, channelID)
+		}
+	})
+
+	return err
+}
+
+// markFiltered records on the queued delivery the notification left by the heartbeat and
+// flapping filters, which retries post without filtering it again.
+func (p *Plugin) markFiltered(d *delivery, message webhook.Message) error {
+	d.Message = message
+	d.Filtered = true
+
+	_, err := p.updateDelivery(d.ID, func(stored *delivery) {
+		stored.Message = message
+		stored.Filtered = true
+	})
+
+	return err
+}
+
+// updateDelivery atomically applies fn to a queued delivery, and returns the updated delivery, or
+// nil if it is no longer queued.
+func (p *Plugin) updateDelivery(id string, fn func(d *delivery)) (*delivery, error) {
+	var updated *delivery
+	err := p.updateKV(deliveryKeyPrefix+id, func(oldValue []byte) (interface{}, error) {
+		updated = nil
+		if len(oldValue) == 0 {
+			return nil, errKVUnchanged
+		}
+
+		var d delivery
+		if err := json.Unmarshal(oldValue, &d); err != nil {
+			return nil, err
+		}
+
+		fn(&d)
+		updated = &d
+		return &d, nil
+	})
+
+	return updated, err
+}
+
+// deliveryBackoff returns the delay before retrying a delivery that failed attempts times.
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := deliveryInitialBackoff
+	for i := 1; i < attempts && backoff < deliveryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > deliveryMaxBackoff {
+		backoff = deliveryMaxBackoff
+	}
+
+	return backoff
+}
+
+// enqueueDelivery durably stores a notification for the delivery worker, and attempts to deliver
+// it right away.
+func (p *Plugin) enqueueDelivery(configID string, message webhook.Message) error {
+	d := &delivery{
+		ID:        model.NewId(),
+		ConfigID:  configID,
+		Message:   message,
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d); err != nil {
+		return fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+
+	go p.deliver(d.ID)
+
+	return nil
+}
+
+// claimDelivery leases a due delivery to this node, so that no other node or worker posts it at
+// the same time. It returns nil if the delivery is gone, not due or claimed by someone else.
+func (p *Plugin) claimDelivery(id string, now time.Time) (*delivery, error) {
+	var claimed *delivery
+	err := p.updateKV(deliveryKeyPrefix+id, func(oldValue []byte) (interface{}, error) {
+		if len(oldValue) == 0 {
+			return nil, errKVUnchanged
+		}
+
+		var d delivery
+		if err := json.Unmarshal(oldValue, &d); err != nil {
+			return nil, err
+		}
+
+		if d.NextAttempt.After(now) || d.ClaimedUntil.After(now) {
+			return nil, errKVUnchanged
+		}
+
+		d.ClaimedUntil = now.Add(deliveryLease)
+		claimed = &d
+		return &d, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// deliver posts a queued notification, and schedules a retry or moves it to the dead letters if
+// it fails.
+func (p *Plugin) deliver(id string) {
+	d, err := p.claimDelivery(id, time.Now())
+	if err != nil {
+		p.API.LogError("Failed to claim notification", "delivery", id, "error", err.Error())
+		return
+	}
+	if d == nil {
+		return
+	}
+
+	alertConfig, ok := p.getConfiguration().AlertConfigs[d.ConfigID]
+	if !ok {
+		p.finishDelivery(d.ID, fmt.Errorf("alert configuration %s not found", d.ConfigID), false)
+		return
+	}
+
+	p.finishDelivery(d.ID, p.processWebhookMessage(alertConfig, d), true)
+}
+
+// finishDelivery dequeues a claimed delivery that succeeded, keeps one buffered by the rate
+// limiter claimed until it is flushed, and schedules a retry of one that failed, or moves it to
+// the dead letters once it is out of attempts or cannot be retried.
+func (p *Plugin) finishDelivery(id string, deliveryErr error, retriable bool) {
+	now := time.Now()
+	if deliveryErr == nil {
+		if err := p.client.KV.Delete(deliveryKeyPrefix + id); err != nil {
+			p.API.LogError("Failed to dequeue notification", "delivery", id, "error", err.Error())
+		}
+		return
+	}
+
+	if errors.Is(deliveryErr, errDeliveryBuffered) {
+		_, err := p.updateDelivery(id, func(d *delivery) {
+			d.ClaimedUntil = now.Add(deliveryBufferedLease)
+		})
+		if err != nil {
+			p.API.LogError("Failed to extend the claim of a buffered notification", "delivery", id, "error", err.Error())
+		}
+		return
+	}
+
+	d, err := p.updateDelivery(id, func(d *delivery) {
+		d.Attempts++
+		d.LastError = deliveryErr.Error()
+		d.ClaimedUntil = time.Time{}
+		d.NextAttempt = now.Add(deliveryBackoff(d.Attempts))
+	})
+	if err != nil {
+		p.API.LogError("Failed to reschedule notification", "delivery", id, "error", err.Error())
+		return
+	}
+	if d == nil {
+		return
+	}
+	p.API.LogWarn("Failed to deliver notification", "delivery", d.ID, "config", d.ConfigID, "attempts", d.Attempts, "error", d.LastError)
+
+	if d.Attempts < deliveryMaxAttempts && retriable {
+		return
+	}
+
+	if _, err := p.client.KV.Set(deadLetterKeyPrefix+d.ID, d, pluginapi.SetExpiry(deadLetterRetention)); err != nil {
+		p.API.LogError("Failed to save dead letter", "delivery", d.ID, "error", err.Error())
+		return
+	}
+	if err := p.client.KV.Delete(deliveryKeyPrefix + d.ID); err != nil {
+		p.API.LogError("Failed to dequeue notification", "delivery", d.ID, "error", err.Error())
+	}
+}
+
+// runDeliveries delivers the queued notifications that are due. It runs as a cluster job, and
+// deliveries are claimed before being posted, so each notification is posted once.
+func (p *Plugin) runDeliveries() {
+	keys, err := p.listKeys(deliveryKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list queued notifications", "error", err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		p.deliver(strings.TrimPrefix(key, deliveryKeyPrefix))
+	}
+}
+
+func (p *Plugin) getDeadLetters() ([]*delivery, error) {
+	keys, err := p.listKeys(deadLetterKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	deadLetters := make([]*delivery, 0, len(keys))
+	for _, key := range keys {
+		var d *delivery
+		if err := p.client.KV.Get(key, &d); err != nil {
+			return nil, fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if d != nil {
+			deadLetters = append(deadLetters, d)
+		}
+	}
+
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].CreatedAt.Before(deadLetters[j].CreatedAt)
+	})
+
+	return deadLetters, nil
+}
+
+// retryDeadLetter moves a dead letter back to the delivery queue with its attempts reset.
+func (p *Plugin) retryDeadLetter(d *delivery) error {
+	d.Attempts = 0
+	d.NextAttempt = time.Time{}
+	d.ClaimedUntil = time.Time{}
+
+	if _, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d); err != nil {
+		return fmt.Errorf("failed to requeue notification: %w", err)
+	}
+	if err := p.client.KV.Delete(deadLetterKeyPrefix + d.ID); err != nil {
+		return fmt.Errorf("failed to remove dead letter: %w", err)
+	}
+
+	go p.deliver(d.ID)
+
+	return nil
+}
+
+func (p *Plugin) handleDeadLetters(args *model.CommandArgs) (string, error) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system administrators can list dead letters.", nil
+	}
+
+	deadLetters, err := p.getDeadLetters()
+	if err != nil {
+		return "", err
+	}
+
+	if len(deadLetters) == 0 {
+		return "No dead letters.", nil
+	}
+
+	msg := "| ID | AlertManager Config ID | Alerts | Received | Attempts | Last error |\n|---|---|---|---|---|---|\n"
+	for _, d := range deadLetters {
+		msg += fmt.Sprintf("| %s | %s | %s | %s | %d | %s |\n", d.ID, d.ConfigID, deadLetterAlerts(d.Message),
+			d.CreatedAt.Format(time.RFC1123), d.Attempts, strings.ReplaceAll(d.LastError, "|", "\\|"))
+	}
+
+	return msg, nil
+}
+
+// deadLetterAlerts summarizes the alerts of a notification, e.g. "DiskFull (2 firing, 0 resolved)".
+func deadLetterAlerts(message webhook.Message) string {
+	if message.Data == nil {
+		return ""
+	}
+
+	names := make(map[string]bool)
+	for _, alert := range message.Alerts {
+		names[alert.Labels[prommodel.AlertNameLabel]] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return fmt.Sprintf("%s (%d firing, %d resolved)", strings.Join(sorted, ", "), len(message.Alerts.Firing()), len(message.Alerts.Resolved()))
+}
+
+func (p *Plugin) handleRetry(args *model.CommandArgs) (string, error) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system administrators can retry dead letters.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	if len(split) != 3 {
+		return "Command requires 1 parameter: dead letter ID, or `all`", nil
+	}
+
+	deadLetters, err := p.getDeadLetters()
+	if err != nil {
+		return "", err
+	}
+
+	retried := 0
+	for _, d := range deadLetters {
+		if split[2] != "all" && split[2] != d.ID {
+			continue
+		}
+		if err := p.retryDeadLetter(d); err != nil {
+			return "", err
+		}
+		retried++
+	}
+
+	if retried == 0 {
+		return fmt.Sprintf("Dead letter %s not found", split[2]), nil
+	}
+
+	return fmt.Sprintf("%d notifications queued for delivery.", retried), nil
+}
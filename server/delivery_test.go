@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, deliveryBackoff(1))
+	assert.Equal(t, 20*time.Second, deliveryBackoff(2))
+	assert.Equal(t, 80*time.Second, deliveryBackoff(4))
+	assert.Equal(t, time.Hour, deliveryBackoff(deliveryMaxAttempts+100))
+}
+
+func TestDeadLetterAlerts(t *testing.T) {
+	message := webhook.Message{Data: &template.Data{Alerts: template.Alerts{
+		{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}},
+		{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}},
+		{Status: "firing", Labels: template.KV{"alertname": "CPUHigh"}},
+	}}}
+
+	assert.Equal(t, "CPUHigh, DiskFull (2 firing, 1 resolved)", deadLetterAlerts(message))
+	assert.Empty(t, deadLetterAlerts(webhook.Message{}))
+}
+
+func TestProcessWebhookMessageRetry(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.ChannelId == "subscribed-id"
+	})).Return(nil, model.NewAppError("CreatePost", "test.post", nil, "", http.StatusInternalServerError)).Once()
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+
+	config := alertConfig{ID: "0"}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+	require.NoError(t, p.updateSubscriptions(func(subscriptions map[string]*subscription) error {
+		subscriptions["s"] = &subscription{ID: "s", ConfigID: "0", ChannelID: "subscribed-id"}
+		return nil
+	}))
+
+	alert := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: time.Now()}
+	d := &delivery{
+		ID:       model.NewId(),
+		ConfigID: "0",
+		Message:  newWebhookMessage("mattermost", "group", "http://alertmanager:9093", template.KV{"alertname": "DiskFull"}, template.Alerts{alert}),
+	}
+	_, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d)
+	require.NoError(t, err)
+
+	assert.Error(t, p.processWebhookMessage(config, d))
+	assert.Equal(t, []string{"subscribed-id", "alerts-id"}, postedChannels(api))
+
+	// The retry, possibly by another node, only posts to the channel that failed.
+	var stored *delivery
+	require.NoError(t, p.client.KV.Get(deliveryKeyPrefix+d.ID, &stored))
+	assert.Equal(t, []string{"alerts-id"}, stored.Posted)
+
+	assert.NoError(t, p.processWebhookMessage(config, stored))
+	assert.Equal(t, []string{"subscribed-id", "alerts-id", "subscribed-id"}, postedChannels(api))
+}
+
+func TestProcessWebhookMessageFiltersOnce(t *testing.T) {
+	p, api, store := newTestPlugin(t)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(nil, model.NewAppError("CreatePost", "test.post", nil, "", http.StatusInternalServerError)).Once()
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+
+	config := alertConfig{ID: "0", HeartbeatMatcher: `alertname="Watchdog"`}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+
+	alerts := template.Alerts{
+		{Status: "firing", Labels: template.KV{"alertname": "Watchdog"}, StartsAt: time.Now()},
+		{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: time.Now()},
+	}
+	d := &delivery{
+		ID:       model.NewId(),
+		ConfigID: "0",
+		Message:  newWebhookMessage("mattermost", "group", "http://alertmanager:9093", template.KV{}, alerts),
+	}
+	_, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d)
+	require.NoError(t, err)
+
+	assert.Error(t, p.processWebhookMessage(config, d))
+	require.NotNil(t, store.get(heartbeatStateKeyPrefix+"0"))
+
+	// The retry posts the filtered notification without seeing the heartbeat again.
+	var stored *delivery
+	require.NoError(t, p.client.KV.Get(deliveryKeyPrefix+d.ID, &stored))
+	assert.True(t, stored.Filtered)
+	require.Len(t, stored.Message.Alerts, 1)
+	assert.Equal(t, "DiskFull", stored.Message.Alerts[0].Labels["alertname"])
+
+	require.NoError(t, p.client.KV.Delete(heartbeatStateKeyPrefix+"0"))
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+	assert.NoError(t, p.processWebhookMessage(config, stored))
+	assert.Nil(t, store.get(heartbeatStateKeyPrefix+"0"))
+	assert.Equal(t, []string{"alerts-id", "alerts-id"}, postedChannels(api))
+}
+
+func TestDeadLetterExpiry(t *testing.T) {
+	p, api, store := newTestPlugin(t)
+
+	d := &delivery{ID: model.NewId(), ConfigID: "0"}
+	_, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d)
+	require.NoError(t, err)
+
+	p.finishDelivery(d.ID, errors.New("channel not found"), false)
+	assert.Nil(t, store.get(deliveryKeyPrefix+d.ID))
+	assert.NotNil(t, store.get(deadLetterKeyPrefix+d.ID))
+	api.AssertCalled(t, "KVSetWithOptions", deadLetterKeyPrefix+d.ID, mock.Anything, mock.MatchedBy(func(options model.PluginKVSetOptions) bool {
+		return options.ExpireInSeconds == int64(deadLetterRetention/time.Second)
+	}))
+}
+
+func TestHandleDeadLettersPermission(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("HasPermissionTo", "user-id", model.PermissionManageSystem).Return(false)
+	api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+
+	msg, err := p.handleDeadLetters(&model.CommandArgs{UserId: "user-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "Only system administrators can list dead letters.", msg)
+
+	msg, err = p.handleDeadLetters(&model.CommandArgs{UserId: "admin-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "No dead letters.", msg)
+}
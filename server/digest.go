@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/robfig/cron/v3"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	digestSchedulesKey = "digest_schedules"
+	digestJobKey       = "digest"
+	digestJobInterval  = time.Minute
+
+	digestLongestFiringCount = 5
+	digestSilenceWindow      = 24 * time.Hour
+	digestNoSeverity         = "none"
+)
+
+var digestCronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// digestSchedule describes a periodic summary of active alerts posted to a channel.
+type digestSchedule struct {
+	ID        string
+	ChannelID string
+	CreatorID string
+	Cron      string
+	Timezone  string
+	// ConfigIDs restricts the digest to the given alert configurations. Empty means all.
+	ConfigIDs []string
+	NextRun   time.Time
+}
+
+// nextRun returns the first time after now the schedule is due, evaluated in its timezone.
+func (d *digestSchedule) nextRun(now time.Time) (time.Time, error) {
+	schedule, err := digestCronParser.Parse(d.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression %q: %w", d.Cron, err)
+	}
+
+	location, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone %q: %w", d.Timezone, err)
+	}
+
+	return schedule.Next(now.In(location)), nil
+}
+
+func (p *Plugin) getDigestSchedules() (map[string]*digestSchedule, error) {
+	schedules := make(map[string]*digestSchedule)
+	if err := p.client.KV.Get(digestSchedulesKey, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to get digest schedules: %w", err)
+	}
+
+	return schedules, nil
+}
+
+// updateDigestSchedules atomically applies fn to the stored digest schedules.
+func (p *Plugin) updateDigestSchedules(fn func(schedules map[string]*digestSchedule) error) error {
+	return p.updateKV(digestSchedulesKey, func(oldValue []byte) (interface{}, error) {
+		schedules := make(map[string]*digestSchedule)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &schedules); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := fn(schedules); err != nil {
+			return nil, err
+		}
+
+		return schedules, nil
+	})
+}
+
+// runDigests posts every digest that is due. It runs as a cluster job, so only one node posts.
+func (p *Plugin) runDigests() {
+	now := time.Now()
+
+	var due []*digestSchedule
+	err := p.updateDigestSchedules(func(schedules map[string]*digestSchedule) error {
+		due = nil
+		for _, schedule := range schedules {
+			if schedule.NextRun.After(now) {
+				continue
+			}
+
+			next, err := schedule.nextRun(now)
+			if err != nil {
+				p.API.LogWarn("Removing invalid digest schedule", "id", schedule.ID, "error", err.Error())
+				delete(schedules, schedule.ID)
+				continue
+			}
+
+			// A zero NextRun means the schedule was never evaluated; wait for its first slot.
+			if !schedule.NextRun.IsZero() {
+				due = append(due, schedule)
+			}
+			schedule.NextRun = next
+		}
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to update digest schedules", "error", err.Error())
+		return
+	}
+
+	for _, schedule := range due {
+		if err := p.postDigest(schedule, now); err != nil {
+			p.API.LogError("Failed to post digest", "id", schedule.ID, "error", err.Error())
+		}
+	}
+}
+
+func (p *Plugin) postDigest(schedule *digestSchedule, now time.Time) error {
+	configuration := p.getConfiguration()
+
+	configIDs := schedule.ConfigIDs
+	if len(configIDs) == 0 {
+		for id := range configuration.AlertConfigs {
+			configIDs = append(configIDs, id)
+		}
+		sort.Strings(configIDs)
+	}
+
+	attachments := make([]*model.SlackAttachment, 0, len(configIDs))
+	for _, id := range configIDs {
+		alertConfig, ok := configuration.AlertConfigs[id]
+		if !ok {
+			continue
+		}
+
+		alerts, err := alertmanager.ListAlerts(alertConfig.AlertManagerURL)
+		if err != nil {
+			attachments = append(attachments, &model.SlackAttachment{
+				Title: fmt.Sprintf("AlertManager Config ID %s", alertConfig.ID),
+				Text:  fmt.Sprintf("Failed to list alerts: %v", err),
+				Color: colorExpired,
+			})
+			continue
+		}
+
+		silences, err := alertmanager.ListSilences(alertConfig.AlertManagerURL)
+		if err != nil {
+			p.API.LogWarn("Failed to list silences for digest", "config", alertConfig.ID, "error", err.Error())
+		}
+
+		attachments = append(attachments, ConvertDigestToSlackAttachment(alertConfig, alerts, silences, now))
+	}
+
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	post := &model.Post{
+		ChannelId: schedule.ChannelID,
+		UserId:    p.BotUserID,
+		Message:   "#### :sunrise: Alert digest",
+	}
+
+	model.ParseSlackAttachment(post, attachments)
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// ConvertDigestToSlackAttachment summarizes the alerts and silences of one alert configuration.
+func ConvertDigestToSlackAttachment(config alertConfig, alerts []*types.Alert, silences []types.Silence, now time.Time) *model.SlackAttachment {
+	var fields []*model.SlackAttachmentField
+
+	if len(alerts) == 0 {
+		fields = addFields(fields, "Active alerts", "No alerts right now! :tada:", false)
+	} else {
+		bySeverity := make(map[string]int)
+		for _, alert := range alerts {
+			severity := string(alert.Labels["severity"])
+			if severity == "" {
+				severity = digestNoSeverity
+			}
+			bySeverity[severity]++
+		}
+
+		severities := make([]string, 0, len(bySeverity))
+		for severity := range bySeverity {
+			severities = append(severities, severity)
+		}
+		sort.Strings(severities)
+
+		var msg string
+		for _, severity := range severities {
+			msg = fmt.Sprintf("%s**%s:** %d\n", msg, severity, bySeverity[severity])
+		}
+		fields = addFields(fields, fmt.Sprintf("Active alerts (%d)", len(alerts)), msg, true)
+
+		longest := make([]*types.Alert, len(alerts))
+		copy(longest, alerts)
+		sort.SliceStable(longest, func(i, j int) bool {
+			return longest[i].StartsAt.Before(longest[j].StartsAt)
+		})
+		if len(longest) > digestLongestFiringCount {
+			longest = longest[:digestLongestFiringCount]
+		}
+
+		msg = ""
+		for _, alert := range longest {
+			msg = fmt.Sprintf("%s**%s:** firing for %s\n", msg, alert.Name(),
+				durafmt.Parse(now.Sub(alert.StartsAt)).LimitFirstN(2).String(),
+			)
+		}
+		fields = addFields(fields, "Longest firing", msg, true)
+	}
+
+	var msg string
+	for _, silence := range silences {
+		if silence.Status.State != types.SilenceStateActive {
+			continue
+		}
+		if silence.EndsAt.Sub(now) > digestSilenceWindow {
+			continue
+		}
+
+		msg = fmt.Sprintf("%s`%s` %s ends in %s\n", msg, silence.ID, silenceMatchersString(silence),
+			durafmt.Parse(silence.EndsAt.Sub(now)).LimitFirstN(2).String(),
+		)
+	}
+	if msg != "" {
+		fields = addFields(fields, "Silences expiring in the next 24h", msg, false)
+	}
+
+	color := colorResolved
+	if len(alerts) > 0 {
+		color = colorFiring
+	}
+
+	return &model.SlackAttachment{
+		Title:  fmt.Sprintf("AlertManager Config ID %s", config.ID),
+		Fields: fields,
+		Color:  color,
+	}
+}
+
+func silenceMatchersString(silence types.Silence) string {
+	matchers := make([]string, 0, len(silence.Matchers))
+	for _, m := range silence.Matchers {
+		matchers = append(matchers, m.String())
+	}
+
+	return strings.Join(matchers, ", ")
+}
+
+func (p *Plugin) handleDigest(args *model.CommandArgs) (string, error) {
+	split := strings.Fields(args.Command)
+	var parameters []string
+	if len(split) > 2 {
+		parameters = split[2:]
+	}
+
+	if len(parameters) == 0 {
+		return digestHelpMsg, nil
+	}
+
+	switch parameters[0] {
+	case "add":
+		return p.handleDigestAdd(args, parameters[1:])
+	case "list":
+		return p.handleDigestList(args)
+	case "remove":
+		return p.handleDigestRemove(args, parameters[1:])
+	default:
+		return digestHelpMsg, nil
+	}
+}
+
+const digestHelpMsg = `run:
+	/alertmanager digest add [cron expression] [timezone] [AlertManager Config ID...] - post a digest to this channel on a schedule, e.g. "0 9 * * 1-5 Europe/Berlin" (channel admins only)
+	/alertmanager digest list - list the digests scheduled for this channel
+	/alertmanager digest remove [Digest ID] - remove a scheduled digest (channel admins only)
+	`
+
+func (p *Plugin) handleDigestAdd(args *model.CommandArgs, parameters []string) (string, error) {
+	// A digest exposes the alerts of every alert configuration to the members of the channel.
+	if !p.isChannelAdmin(args.UserId, args.ChannelId) {
+		return "Only channel admins can schedule digests in this channel.", nil
+	}
+
+	// Descriptors such as @daily are a single token, standard expressions span five.
+	cronFields := 5
+	if len(parameters) > 0 && strings.HasPrefix(parameters[0], "@") {
+		cronFields = 1
+	}
+
+	if len(parameters) < cronFields+1 {
+		return "Command requires a cron expression and a timezone, e.g. `/alertmanager digest add 0 9 * * 1-5 Europe/Berlin`", nil
+	}
+
+	schedule := &digestSchedule{
+		ID:        model.NewId(),
+		ChannelID: args.ChannelId,
+		CreatorID: args.UserId,
+		Cron:      strings.Join(parameters[:cronFields], " "),
+		Timezone:  parameters[cronFields],
+		ConfigIDs: parameters[cronFields+1:],
+	}
+
+	configuration := p.getConfiguration()
+	for _, id := range schedule.ConfigIDs {
+		if _, ok := configuration.AlertConfigs[id]; !ok {
+			return fmt.Sprintf("Alert configuration %s not found", id), nil
+		}
+	}
+
+	next, err := schedule.nextRun(time.Now())
+	if err != nil {
+		return err.Error(), nil
+	}
+	schedule.NextRun = next
+
+	err = p.updateDigestSchedules(func(schedules map[string]*digestSchedule) error {
+		schedules[schedule.ID] = schedule
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save the digest: %w", err)
+	}
+
+	return fmt.Sprintf("Digest %s scheduled. Next digest at %s.", schedule.ID, next.Format(time.RFC1123)), nil
+}
+
+func (p *Plugin) handleDigestList(args *model.CommandArgs) (string, error) {
+	schedules, err := p.getDigestSchedules()
+	if err != nil {
+		return "", err
+	}
+
+	var channelSchedules []*digestSchedule
+	for _, schedule := range schedules {
+		if schedule.ChannelID == args.ChannelId {
+			channelSchedules = append(channelSchedules, schedule)
+		}
+	}
+
+	if len(channelSchedules) == 0 {
+		return "No digests scheduled for this channel.", nil
+	}
+
+	sort.Slice(channelSchedules, func(i, j int) bool {
+		return channelSchedules[i].NextRun.Before(channelSchedules[j].NextRun)
+	})
+
+	msg := "| ID | Schedule | Timezone | AlertManager Config IDs | Next digest |\n|---|---|---|---|---|\n"
+	for _, schedule := range channelSchedules {
+		configIDs := "all"
+		if len(schedule.ConfigIDs) > 0 {
+			configIDs = strings.Join(schedule.ConfigIDs, ", ")
+		}
+		msg += fmt.Sprintf("| %s | `%s` | %s | %s | %s |\n", schedule.ID, schedule.Cron, schedule.Timezone, configIDs, schedule.NextRun.Format(time.RFC1123))
+	}
+
+	return msg, nil
+}
+
+func (p *Plugin) handleDigestRemove(args *model.CommandArgs, parameters []string) (string, error) {
+	if !p.isChannelAdmin(args.UserId, args.ChannelId) {
+		return "Only channel admins can remove the digests of this channel.", nil
+	}
+
+	if len(parameters) != 1 {
+		return "Command requires 1 parameter: digest ID", nil
+	}
+
+	found := false
+	err := p.updateDigestSchedules(func(schedules map[string]*digestSchedule) error {
+		schedule, ok := schedules[parameters[0]]
+		found = ok && schedule.ChannelID == args.ChannelId
+		if found {
+			delete(schedules, parameters[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to remove the digest: %w", err)
+	}
+
+	if !found {
+		return fmt.Sprintf("Digest %s not found in this channel", parameters[0]), nil
+	}
+
+	return fmt.Sprintf("Digest %s removed.", parameters[0]), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/alertmanager/types"
+	prommodel "github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestScheduleNextRun(t *testing.T) {
+	now := time.Date(2023, 3, 6, 7, 30, 0, 0, time.UTC) // Monday
+
+	schedule := &digestSchedule{Cron: "0 9 * * 1-5", Timezone: "Europe/Berlin"}
+	next, err := schedule.nextRun(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 3, 6, 8, 0, 0, 0, time.UTC), next.UTC())
+
+	schedule = &digestSchedule{Cron: "@daily", Timezone: "UTC"}
+	next, err = schedule.nextRun(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 3, 7, 0, 0, 0, 0, time.UTC), next.UTC())
+
+	schedule = &digestSchedule{Cron: "0 9 * *", Timezone: "UTC"}
+	_, err = schedule.nextRun(now)
+	assert.Error(t, err)
+
+	schedule = &digestSchedule{Cron: "0 9 * * *", Timezone: "Mars/Olympus"}
+	_, err = schedule.nextRun(now)
+	assert.Error(t, err)
+}
+
+func TestConvertDigestToSlackAttachment(t *testing.T) {
+	now := time.Now()
+	alerts := []*types.Alert{
+		{Alert: prommodel.Alert{Labels: prommodel.LabelSet{"alertname": "DiskFull", "severity": "critical"}, StartsAt: now.Add(-3 * time.Hour)}},
+		{Alert: prommodel.Alert{Labels: prommodel.LabelSet{"alertname": "HighLatency", "severity": "warning"}, StartsAt: now.Add(-time.Hour)}},
+		{Alert: prommodel.Alert{Labels: prommodel.LabelSet{"alertname": "Watchdog"}, StartsAt: now.Add(-2 * time.Hour)}},
+	}
+	silences := []types.Silence{
+		{ID: "soon", EndsAt: now.Add(time.Hour), Status: types.SilenceStatus{State: types.SilenceStateActive}},
+		{ID: "later", EndsAt: now.Add(48 * time.Hour), Status: types.SilenceStatus{State: types.SilenceStateActive}},
+	}
+
+	attachment := ConvertDigestToSlackAttachment(alertConfig{ID: "0"}, alerts, silences, now)
+	require.Len(t, attachment.Fields, 3)
+	assert.Equal(t, "Active alerts (3)", attachment.Fields[0].Title)
+	assert.Equal(t, "**critical:** 1\n**none:** 1\n**warning:** 1\n", attachment.Fields[0].Value)
+	assert.Regexp(t, "^\\*\\*DiskFull:\\*\\*.*\n\\*\\*Watchdog:\\*\\*.*\n\\*\\*HighLatency:\\*\\*", attachment.Fields[1].Value)
+	assert.Contains(t, attachment.Fields[2].Value, "`soon`")
+	assert.NotContains(t, attachment.Fields[2].Value, "`later`")
+	assert.Equal(t, colorFiring, attachment.Color)
+
+	attachment = ConvertDigestToSlackAttachment(alertConfig{ID: "0"}, nil, nil, now)
+	require.Len(t, attachment.Fields, 1)
+	assert.Equal(t, colorResolved, attachment.Color)
+}
+
+func TestDigestPermission(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("HasPermissionTo", "user-id", model.PermissionManageSystem).Return(false)
+	api.On("GetChannelMember", "channel-id", "user-id").Return(&model.ChannelMember{ChannelId: "channel-id", UserId: "user-id"}, nil)
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": {ID: "0"}}})
+
+	msg, err := p.handleDigest(&model.CommandArgs{UserId: "user-id", ChannelId: "channel-id", Command: "/alertmanager digest add @daily UTC 0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Only channel admins can schedule digests in this channel.", msg)
+
+	msg, err = p.handleDigest(&model.CommandArgs{UserId: "user-id", ChannelId: "channel-id", Command: "/alertmanager digest remove digest-id"})
+	require.NoError(t, err)
+	assert.Equal(t, "Only channel admins can remove the digests of this channel.", msg)
+
+	schedules, err := p.getDigestSchedules()
+	require.NoError(t, err)
+	assert.Empty(t, schedules)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	defaultFireDuration = "1h"
+
+	// fireAPIPath receives the fire dialog submissions. The server authenticates the user who
+	// submits the dialog, so the URL sent to the browser holds no token.
+	fireAPIPath = "/api/fire"
+)
+
+// parseLabel parses a label=value pair.
+func parseLabel(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !prommodel.LabelName(name).IsValid() {
+		return "", "", fmt.Errorf("invalid label %q, labels must have the form label=value", s)
+	}
+
+	return name, value, nil
+}
+
+// fireDialog asks for the alert to fire with the alert config.
+func fireDialog(config alertConfig) model.Dialog {
+	return model.Dialog{
+		CallbackId:       config.ID,
+		Title:            "Fire an alert",
+		IntroductionText: fmt.Sprintf("The alert is sent to the Alertmanager of AlertManager Config ID %s, and routed like the alerts of Prometheus.", config.ID),
+		SubmitLabel:      "Fire",
+		Elements: []model.DialogElement{{
+			DisplayName: "Alert name",
+			Name:        "alertname",
+			Type:        "text",
+			Placeholder: "CheckoutErrors",
+		}, {
+			DisplayName: "Severity",
+			Name:        "severity",
+			Type:        "select",
+			Default:     "warning",
+			Options: []*model.PostActionOptions{
+				{Text: "Critical", Value: "critical"},
+				{Text: "Warning", Value: "warning"},
+				{Text: "Info", Value: "info"},
+			},
+		}, {
+			DisplayName: "Labels",
+			Name:        "labels",
+			Type:        "text",
+			Optional:    true,
+			Placeholder: "team=payments service=checkout",
+			HelpText:    "Additional labels used to route the alert, separated by spaces.",
+		}, {
+			DisplayName: "Summary",
+			Name:        "summary",
+			Type:        "textarea",
+			Placeholder: "Customers report errors when checking out.",
+		}, {
+			DisplayName: "Duration",
+			Name:        "duration",
+			Type:        "text",
+			Default:     defaultFireDuration,
+			HelpText:    "The alert resolves after this duration, e.g. 30m, 4h or 1d.",
+		}},
+	}
+}
+
+// parseFireSubmission returns the labels, annotations and duration of a submitted fire dialog,
+// or the errors of its fields.
+func parseFireSubmission(submission map[string]interface{}) (labels, annotations map[string]string, duration time.Duration, errs map[string]string) {
+	field := func(name string) string {
+		value, _ := submission[name].(string)
+		return strings.TrimSpace(value)
+	}
+
+	errs = make(map[string]string)
+	labels = make(map[string]string)
+	for _, s := range strings.Fields(field("labels")) {
+		name, value, err := parseLabel(s)
+		if err != nil {
+			errs["labels"] = err.Error()
+			break
+		}
+		labels[name] = value
+	}
+
+	labels[prommodel.AlertNameLabel] = field("alertname")
+	if labels[prommodel.AlertNameLabel] == "" {
+		errs["alertname"] = "Alert name is required"
+	}
+
+	labels["severity"] = field("severity")
+	if labels["severity"] == "" {
+		errs["severity"] = "Severity is required"
+	}
+
+	annotations = map[string]string{"summary": field("summary")}
+	if annotations["summary"] == "" {
+		errs["summary"] = "Summary is required"
+	}
+
+	d, err := prommodel.ParseDuration(field("duration"))
+	if err != nil || d <= 0 {
+		errs["duration"] = "Duration must be positive, e.g. 30m, 4h or 1d"
+	}
+
+	return labels, annotations, time.Duration(d), errs
+}
+
+// canFire reports whether a user can fire alerts with an alert config, which requires being an
+// admin of its alert channel.
+func (p *Plugin) canFire(userID string, config alertConfig) bool {
+	channelID := p.getAlertChannelID(config.ID)
+	return channelID != "" && p.isChannelAdmin(userID, channelID)
+}
+
+func (p *Plugin) handleFire(args *model.CommandArgs) (string, error) {
+	split := strings.Fields(args.Command)
+	if len(split) != 3 {
+		return "Command requires 1 parameter: AlertManager Config ID", nil
+	}
+
+	config, ok := p.getConfiguration().AlertConfigs[split[2]]
+	if !ok {
+		return fmt.Sprintf("Alert configuration %s not found", split[2]), nil
+	}
+
+	if !p.canFire(args.UserId, config) {
+		return "Only admins of the alert channel can fire alerts.", nil
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: args.TriggerId,
+		URL:       fmt.Sprintf("/plugins/%s%s", manifest.ID, fireAPIPath),
+		Dialog:    fireDialog(config),
+	})
+	if appErr != nil {
+		return "", fmt.Errorf("failed to open the dialog: %w", appErr)
+	}
+
+	return "", nil
+}
+
+// postPermalink returns the URL of a post, which Alertmanager shows as the source of the alert.
+func (p *Plugin) postPermalink(teamName, postID string) string {
+	return fmt.Sprintf("%s/%s/pl/%s", strings.TrimRight(p.siteURL(), "/"), teamName, postID)
+}
+
+// handleFireSubmission fires the alert of a submitted fire dialog, whose callback ID is the ID of
+// the alert config, and announces it in the alert channel.
+func (p *Plugin) handleFireSubmission(w http.ResponseWriter, r *http.Request) {
+	p.API.LogInfo("Received fire dialog submission")
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request *model.SubmitDialogRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	if request == nil {
+		http.Error(w, "We could not decode the dialog submission", http.StatusBadRequest)
+		return
+	}
+	if request.Cancelled {
+		return
+	}
+
+	writeResponse := func(response *model.SubmitDialogResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}
+
+	config, ok := p.getConfiguration().AlertConfigs[request.CallbackId]
+	if !ok {
+		writeResponse(&model.SubmitDialogResponse{Error: fmt.Sprintf("Alert configuration %s not found", request.CallbackId)})
+		return
+	}
+
+	// The permission is checked again, as any user can submit the dialog.
+	if !p.canFire(userID, config) {
+		writeResponse(&model.SubmitDialogResponse{Error: "Only admins of the alert channel can fire alerts."})
+		return
+	}
+
+	labels, annotations, duration, errs := parseFireSubmission(request.Submission)
+	if len(errs) > 0 {
+		writeResponse(&model.SubmitDialogResponse{Errors: errs})
+		return
+	}
+
+	firedBy := userID
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		firedBy = "@" + user.Username
+	}
+
+	now := time.Now()
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: p.getAlertChannelID(config.ID),
+		Message: fmt.Sprintf("%s fired the alert **%s** (%s) until %s:\n> %s",
+			firedBy, labels[prommodel.AlertNameLabel], labels["severity"], formatTime(config, now.Add(duration)), strings.ReplaceAll(annotations["summary"], "\n", "\n> ")),
+	}
+	post, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		writeResponse(&model.SubmitDialogResponse{Error: fmt.Sprintf("Failed to create the post: %v", appErr)})
+		return
+	}
+
+	annotations["description"] = fmt.Sprintf("Fired by %s from Mattermost", firedBy)
+	alert := alertmanager.PostableAlert{
+		Labels:       labels,
+		Annotations:  annotations,
+		StartsAt:     now,
+		EndsAt:       now.Add(duration),
+		GeneratorURL: p.postPermalink(config.Team, post.Id),
+	}
+	if err := alertmanager.PostAlerts([]alertmanager.PostableAlert{alert}, config.AlertManagerURL); err != nil {
+		_ = p.API.DeletePost(post.Id)
+		writeResponse(&model.SubmitDialogResponse{Error: fmt.Sprintf("Failed to send the alert to Alertmanager: %v", err)})
+		return
+	}
+
+	writeResponse(&model.SubmitDialogResponse{})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFireSubmission(t *testing.T) {
+	labels, annotations, duration, errs := parseFireSubmission(map[string]interface{}{
+		"alertname": " CheckoutErrors ",
+		"severity":  "critical",
+		"labels":    "team=payments service=checkout",
+		"summary":   "Customers report errors when checking out.",
+		"duration":  "1d",
+	})
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]string{
+		"alertname": "CheckoutErrors",
+		"severity":  "critical",
+		"team":      "payments",
+		"service":   "checkout",
+	}, labels)
+	assert.Equal(t, map[string]string{"summary": "Customers report errors when checking out."}, annotations)
+	assert.Equal(t, 24*time.Hour, duration)
+
+	_, _, _, errs = parseFireSubmission(map[string]interface{}{
+		"severity": "warning",
+		"labels":   "team",
+		"duration": "soon",
+	})
+	assert.Len(t, errs, 4)
+	assert.Contains(t, errs, "alertname")
+	assert.Contains(t, errs, "labels")
+	assert.Contains(t, errs, "summary")
+	assert.Contains(t, errs, "duration")
+}
+
+func TestFirePermission(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("HasPermissionTo", mock.AnythingOfType("string"), model.PermissionManageSystem).Return(false)
+	api.On("GetChannelMember", "alerts-id", "user-id").Return(&model.ChannelMember{ChannelId: "alerts-id", UserId: "user-id"}, nil)
+	api.On("GetChannelMember", "alerts-id", "admin-id").Return(&model.ChannelMember{ChannelId: "alerts-id", UserId: "admin-id", SchemeAdmin: true}, nil)
+	api.On("GetChannelMember", "other-id", "user-id").Return(&model.ChannelMember{ChannelId: "other-id", UserId: "user-id", SchemeAdmin: true}, nil)
+	api.On("OpenInteractiveDialog", mock.AnythingOfType("model.OpenDialogRequest")).Return(nil)
+
+	config := alertConfig{ID: "0", Token: "0123456789abcdefghijklmnopqrstuv", AlertManagerURL: "http://alertmanager:9093"}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+
+	// Admins of other channels cannot fire alerts.
+	msg, err := p.handleFire(&model.CommandArgs{UserId: "user-id", ChannelId: "other-id", Command: "/alertmanager fire 0"})
+	require.NoError(t, err)
+	assert.Equal(t, "Only admins of the alert channel can fire alerts.", msg)
+
+	// The dialog URL does not hold the token.
+	msg, err = p.handleFire(&model.CommandArgs{UserId: "admin-id", ChannelId: "alerts-id", Command: "/alertmanager fire 0"})
+	require.NoError(t, err)
+	assert.Empty(t, msg)
+	api.AssertCalled(t, "OpenInteractiveDialog", mock.MatchedBy(func(request model.OpenDialogRequest) bool {
+		return request.URL == "/plugins/"+manifest.ID+"/api/fire" && request.Dialog.CallbackId == "0"
+	}))
+
+	// Channel members cannot fire alerts by submitting the dialog with the IDs of an admin.
+	body, err := json.Marshal(&model.SubmitDialogRequest{
+		UserId:     "admin-id",
+		ChannelId:  "alerts-id",
+		CallbackId: "0",
+		Submission: map[string]interface{}{
+			"alertname": "CheckoutErrors",
+			"severity":  "critical",
+			"summary":   "Customers report errors when checking out.",
+			"duration":  "1h",
+		},
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/api/fire", bytes.NewReader(body))
+	r.Header.Set("Mattermost-User-Id", "user-id")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, r)
+
+	var response model.SubmitDialogResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "Only admins of the alert channel can fire alerts.", response.Error)
+	api.AssertNotCalled(t, "CreatePost", mock.Anything)
+
+	// The submissions of unauthenticated users are rejected.
+	w = httptest.NewRecorder()
+	p.ServeHTTP(nil, w, httptest.NewRequest(http.MethodPost, "/api/fire?token="+config.Token, bytes.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	flapKeyPrefix        = "flap_"
+	flapJobKey           = "flapping"
+	flapJobInterval      = time.Minute
+	flapSilenceDuration  = time.Hour
+	flapDefaultWindow    = 30 * time.Minute
+	flapDefaultStability = 30 * time.Minute
+	// flapStateRetention is how long the state of an alert is kept after its last notification,
+	// so that a change of its status is still seen as a transition.
+	flapStateRetention = 24 * time.Hour
+)
+
+// flapState tracks the state transitions of a single alert, identified by its fingerprint.
+type flapState struct {
+	ConfigID string
+	Labels   template.KV
+	Status   string
+	// LastSeen is when the alert was last notified.
+	LastSeen    time.Time
+	Transitions []time.Time
+	Flapping    bool
+	// FlapCount is the number of transitions collapsed into the flapping post.
+	FlapCount int
+	PostID    string
+	ChannelID string
+}
+
+func flapKey(configID, fingerprint string) string {
+	return fmt.Sprintf("%s%s_%s", flapKeyPrefix, configID, fingerprint)
+}
+
+// flapSettings returns the flapping window and stability period of a config, with defaults for
+// unset values.
+func flapSettings(config alertConfig) (window, stable time.Duration) {
+	window, stable = flapDefaultWindow, flapDefaultStability
+	if config.FlapWindow > 0 {
+		window = time.Duration(config.FlapWindow) * time.Minute
+	}
+	if config.FlapStablePeriod > 0 {
+		stable = time.Duration(config.FlapStablePeriod) * time.Minute
+	}
+
+	return window, stable
+}
+
+// lastTransition returns the time of the most recent state transition, or the zero time.
+func (s *flapState) lastTransition() time.Time {
+	if len(s.Transitions) == 0 {
+		return time.Time{}
+	}
+
+	return s.Transitions[len(s.Transitions)-1]
+}
+
+// record registers the status of the alert at now. The alert starts flapping once threshold
+// transitions happened within window, and stops after being stable for the stable period.
+func (s *flapState) record(status string, now time.Time, window, stable time.Duration, threshold int) {
+	if s.Flapping && now.Sub(s.lastTransition()) >= stable {
+		s.Flapping = false
+		s.FlapCount = 0
+		s.PostID = ""
+		s.Transitions = nil
+	}
+
+	if s.Status != "" && s.Status != status {
+		s.Transitions = append(s.Transitions, now)
+		if s.Flapping {
+			s.FlapCount++
+		}
+	}
+	s.Status = status
+	s.LastSeen = now
+
+	transitions := s.Transitions[:0]
+	for _, transition := range s.Transitions {
+		if now.Sub(transition) <= window {
+			transitions = append(transitions, transition)
+		}
+	}
+	s.Transitions = transitions
+
+	if !s.Flapping && len(s.Transitions) >= threshold {
+		s.Flapping = true
+		s.FlapCount = len(s.Transitions)
+	}
+}
+
+// filterFlapping records the transitions of the alerts in message and collapses those of
+// flapping alerts into a single post per alert. It returns false if no alerts are left to post.
+func (p *Plugin) filterFlapping(config alertConfig, message webhook.Message) (webhook.Message, bool) {
+	if config.FlapThreshold <= 0 {
+		return message, true
+	}
+
+	window, stable := flapSettings(config)
+	now := time.Now()
+
+	var alerts template.Alerts
+	for _, alert := range message.Alerts {
+		if alert.Fingerprint == "" {
+			alerts = append(alerts, alert)
+			continue
+		}
+
+		key := flapKey(config.ID, alert.Fingerprint)
+		var state flapState
+		err := p.updateFlapState(key, func(oldState *flapState) *flapState {
+			if oldState == nil {
+				oldState = &flapState{ConfigID: config.ID}
+			}
+			oldState.Labels = alert.Labels
+			oldState.record(alert.Status, now, window, stable, config.FlapThreshold)
+			state = *oldState
+			return oldState
+		})
+		if err != nil {
+			p.API.LogError("Failed to record alert transition", "key", key, "error", err.Error())
+			alerts = append(alerts, alert)
+			continue
+		}
+
+		if !state.Flapping {
+			alerts = append(alerts, alert)
+			continue
+		}
+
+		p.upsertFlappingPost(config, key, &state)
+	}
+
+	if len(alerts) == len(message.Alerts) {
+		return message, true
+	}
+	if len(alerts) == 0 {
+		return message, false
+	}
+
+	return withAlerts(message, alerts), true
+}
+
+func (p *Plugin) updateFlapState(key string, fn func(state *flapState) *flapState) error {
+	return p.updateKV(key, func(oldValue []byte) (interface{}, error) {
+		var state *flapState
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &state); err != nil {
+				return nil, err
+			}
+		}
+
+		state = fn(state)
+		if state == nil {
+			if len(oldValue) == 0 {
+				return nil, errKVUnchanged
+			}
+			return nil, nil
+		}
+
+		return state, nil
+	})
+}
+
+// upsertFlappingPost creates the post collapsing the transitions of a flapping alert, or updates
+// its flap counter.
+func (p *Plugin) upsertFlappingPost(config alertConfig, key string, state *flapState) {
+	attachment := p.convertFlappingAlertToSlackAttachment(config, key, state)
+
+	if state.PostID != "" {
+		post, appErr := p.API.GetPost(state.PostID)
+		if appErr == nil {
+			model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+			if _, appErr = p.API.UpdatePost(post); appErr == nil {
+				return
+			}
+		}
+		p.API.LogWarn("Failed to update flapping post, creating a new one", "key", key, "error", appErr.Error())
+	}
+
+	post := &model.Post{
+		ChannelId: p.getAlertChannelID(config.ID),
+		UserId:    p.BotUserID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+	createdPost, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		p.API.LogError("Failed to post flapping alert", "key", key, "error", appErr.Error())
+		return
+	}
+
+	err := p.updateFlapState(key, func(oldState *flapState) *flapState {
+		if oldState != nil {
+			oldState.PostID = createdPost.Id
+			oldState.ChannelID = createdPost.ChannelId
+		}
+		return oldState
+	})
+	if err != nil {
+		p.API.LogError("Failed to save flapping post", "key", key, "error", err.Error())
+	}
+}
+
+func (p *Plugin) convertFlappingAlertToSlackAttachment(config alertConfig, key string, state *flapState) *model.SlackAttachment {
+	var fields []*model.SlackAttachmentField
+	fields = addFields(fields, "Current status", strings.ToUpper(state.Status), true)
+	fields = addFields(fields, "Flap count", strconv.Itoa(state.FlapCount), true)
+	fields = addFields(fields, "Last transition", formatTime(config, state.lastTransition()), true)
+	fields = addFields(fields, "Labels", formatKV(state.Labels), false)
+	fields = addFields(fields, "AlertManager Config ID", config.ID, true)
+
+	return &model.SlackAttachment{
+		Title:  fmt.Sprintf(":ping_pong: %s is flapping", state.Labels[prommodel.AlertNameLabel]),
+		Text:   "Further transitions of this alert are collapsed into this post until it is stable.",
+		Fields: fields,
+		Color:  setColor(state.Status),
+		Actions: []*model.PostAction{{
+			Name: "Silence this flapping alert",
+			Type: model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				Context: map[string]interface{}{
+					"action":   "silence_flapping",
+					"flap_key": key,
+				},
+				URL: p.getActionURL(config, "silence_flapping"),
+			},
+		}},
+	}
+}
+
+// formatKV renders key/value pairs as sorted markdown lines.
+func formatKV(kv template.KV) string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var msg string
+	for _, k := range keys {
+		msg = fmt.Sprintf("%s**%s:** %s\n", msg, k, kv[k])
+	}
+
+	return msg
+}
+
+// runFlappingChecks finalizes the posts of alerts that stopped flapping, and forgets the alerts
+// that were not notified for longer than the window and flapStateRetention. It runs as a cluster
+// job, so only one node updates posts.
+func (p *Plugin) runFlappingChecks() {
+	keys, err := p.listKeys(flapKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list flapping states", "error", err.Error())
+		return
+	}
+
+	configuration := p.getConfiguration()
+	now := time.Now()
+	for _, key := range keys {
+		var stopped *flapState
+		err := p.updateFlapState(key, func(state *flapState) *flapState {
+			stopped = nil
+			if state == nil {
+				return nil
+			}
+
+			config, ok := configuration.AlertConfigs[state.ConfigID]
+			if !ok || config.FlapThreshold <= 0 {
+				return nil
+			}
+
+			window, stable := flapSettings(config)
+			if state.Flapping {
+				if now.Sub(state.lastTransition()) < stable {
+					return state
+				}
+				stopped = state
+				return nil
+			}
+
+			retention := flapStateRetention
+			if window > retention {
+				retention = window
+			}
+			if now.Sub(state.LastSeen) > retention {
+				return nil
+			}
+
+			return state
+		})
+		if err != nil {
+			p.API.LogError("Failed to check flapping state", "key", key, "error", err.Error())
+			continue
+		}
+
+		if stopped != nil && stopped.PostID != "" {
+			p.finalizeFlappingPost(stopped, now)
+		}
+	}
+}
+
+func (p *Plugin) finalizeFlappingPost(state *flapState, now time.Time) {
+	post, appErr := p.API.GetPost(state.PostID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get flapping post", "post", state.PostID, "error", appErr.Error())
+		return
+	}
+
+	attachments := post.Attachments()
+	for _, attachment := range attachments {
+		attachment.Actions = nil
+		attachment.Color = setColor(state.Status)
+		attachment.Text = fmt.Sprintf("Stable as %s for %s, no longer flapping.",
+			strings.ToUpper(state.Status),
+			durafmt.Parse(now.Sub(state.lastTransition())).LimitFirstN(2).String(),
+		)
+	}
+
+	model.ParseSlackAttachment(post, attachments)
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+	}
+}
+
+func (p *Plugin) handleSilenceFlappingAction(w http.ResponseWriter, r *http.Request, alertConfig alertConfig) {
+	p.API.LogInfo("Received silence flapping alert action")
+
+	var action *Action
+	_ = json.NewDecoder(r.Body).Decode(&action)
+
+	if action == nil || action.Context == nil {
+		encodeEphermalMessage(w, "We could not decode the action")
+		return
+	}
+
+	key := action.Context.FlapKey
+	if !strings.HasPrefix(key, flapKeyPrefix+alertConfig.ID+"_") {
+		encodeEphermalMessage(w, "Invalid alert")
+		return
+	}
+
+	var state *flapState
+	if err := p.client.KV.Get(key, &state); err != nil || state == nil {
+		encodeEphermalMessage(w, "This alert is no longer flapping.")
+		return
+	}
+
+	username := "alertmanagerbot"
+	if user, appErr := p.API.GetUser(action.UserID); appErr == nil {
+		username = user.Username
+	}
+
+	var matchers labels.Matchers
+	for k, v := range state.Labels {
+		matcher, err := labels.NewMatcher(labels.MatchEqual, k, v)
+		if err != nil {
+			encodeEphermalMessage(w, fmt.Sprintf("failed to silence the alert: %v", err))
+			return
+		}
+		matchers = append(matchers, matcher)
+	}
+	sort.Sort(matchers)
+
+	now := time.Now()
+	silenceID, err := alertmanager.CreateSilence(types.Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(flapSilenceDuration),
+		CreatedBy: username,
+		Comment:   "Silenced from Mattermost because the alert is flapping",
+	}, alertConfig.AlertManagerURL)
+	if err != nil {
+		encodeEphermalMessage(w, fmt.Sprintf("failed to silence the alert: %v", err))
+		return
+	}
+
+	resultMsg := fmt.Sprintf("Silenced by @%s for %s (silence %s)", username, durafmt.Parse(flapSilenceDuration).String(), silenceID)
+
+	actionPost, appErr := p.API.GetPost(action.PostID)
+	if appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+		encodeEphermalMessage(w, resultMsg)
+		return
+	}
+
+	attachments := actionPost.Attachments()
+	for _, attachment := range attachments {
+		attachment.Actions = nil
+		attachment.Color = colorExpired
+		attachment.Fields = addFields(attachment.Fields, "🔕", resultMsg, false)
+	}
+
+	model.ParseSlackAttachment(actionPost, attachments)
+	if _, appErr := p.API.UpdatePost(actionPost); appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+	}
+
+	encodeEphermalMessage(w, resultMsg)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlapStateRecord(t *testing.T) {
+	now := time.Now()
+	window := 30 * time.Minute
+	stable := 20 * time.Minute
+
+	state := &flapState{}
+	state.record("firing", now, window, stable, 3)
+	assert.Empty(t, state.Transitions)
+
+	state.record("firing", now.Add(time.Minute), window, stable, 3)
+	assert.Empty(t, state.Transitions)
+
+	state.record("resolved", now.Add(2*time.Minute), window, stable, 3)
+	state.record("firing", now.Add(3*time.Minute), window, stable, 3)
+	assert.False(t, state.Flapping)
+
+	state.record("resolved", now.Add(4*time.Minute), window, stable, 3)
+	assert.True(t, state.Flapping)
+	assert.Equal(t, 3, state.FlapCount)
+
+	state.record("firing", now.Add(5*time.Minute), window, stable, 3)
+	assert.True(t, state.Flapping)
+	assert.Equal(t, 4, state.FlapCount)
+
+	// Stable for longer than the stable period.
+	state.record("resolved", now.Add(30*time.Minute), window, stable, 3)
+	assert.False(t, state.Flapping)
+	assert.Equal(t, 0, state.FlapCount)
+	assert.Len(t, state.Transitions, 1)
+}
+
+func TestFlapStateRecordWindow(t *testing.T) {
+	now := time.Now()
+	window := 10 * time.Minute
+
+	state := &flapState{}
+	state.record("firing", now, window, window, 3)
+	state.record("resolved", now.Add(time.Minute), window, window, 3)
+	state.record("firing", now.Add(2*time.Minute), window, window, 3)
+	state.record("resolved", now.Add(15*time.Minute), window, window, 3)
+	assert.False(t, state.Flapping)
+	assert.Len(t, state.Transitions, 1)
+}
+
+func TestRunFlappingChecks(t *testing.T) {
+	p, _, store := newTestPlugin(t)
+	config := alertConfig{ID: "0", FlapThreshold: 3}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+
+	window, stable := flapSettings(config)
+	key := flapKey("0", "fingerprint")
+	record := func(status string, at time.Time) {
+		require.NoError(t, p.updateFlapState(key, func(state *flapState) *flapState {
+			if state == nil {
+				state = &flapState{ConfigID: "0"}
+			}
+			state.record(status, at, window, stable, config.FlapThreshold)
+			return state
+		}))
+	}
+	load := func() *flapState {
+		var state *flapState
+		require.NoError(t, p.client.KV.Get(key, &state))
+		return state
+	}
+
+	// The checks keep the status of alerts without transitions, so that the next notification
+	// still counts as one.
+	now := time.Now()
+	record("firing", now.Add(-4*time.Minute))
+	p.runFlappingChecks()
+	record("resolved", now.Add(-3*time.Minute))
+	p.runFlappingChecks()
+	record("firing", now.Add(-2*time.Minute))
+	p.runFlappingChecks()
+	record("resolved", now.Add(-time.Minute))
+	p.runFlappingChecks()
+
+	state := load()
+	require.NotNil(t, state)
+	assert.True(t, state.Flapping)
+	assert.Equal(t, 3, state.FlapCount)
+
+	// Alerts not notified for longer than the retention are forgotten.
+	state = &flapState{ConfigID: "0", Status: "firing", LastSeen: now.Add(-flapStateRetention - time.Minute)}
+	b, err := json.Marshal(state)
+	require.NoError(t, err)
+	store.set(key, b)
+	p.runFlappingChecks()
+	assert.Nil(t, load())
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hako/durafmt"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	healthJobKey         = "health"
+	healthJobInterval    = time.Minute
+	healthStateKeyPrefix = "health_"
+)
+
+type healthEvent int
+
+const (
+	healthEventUnreachable healthEvent = iota
+	healthEventRecovered
+	healthEventRestarted
+)
+
+// healthState tracks the availability of an Alertmanager across health checks.
+type healthState struct {
+	ConsecutiveFailures int
+	FailingSince        time.Time
+	Unreachable         bool
+	LastError           string
+	// Uptime is the start time last reported by the Alertmanager.
+	Uptime time.Time
+}
+
+// next returns the state after a health check that returned uptime or failed with err, together
+// with the events to report. An Alertmanager is unreachable after threshold consecutive failures.
+func (s healthState) next(uptime time.Time, err error, threshold int, now time.Time) (healthState, []healthEvent) {
+	var events []healthEvent
+
+	if err != nil {
+		if s.ConsecutiveFailures == 0 {
+			s.FailingSince = now
+		}
+		s.ConsecutiveFailures++
+		s.LastError = err.Error()
+
+		if !s.Unreachable && s.ConsecutiveFailures >= threshold {
+			s.Unreachable = true
+			events = append(events, healthEventUnreachable)
+		}
+
+		return s, events
+	}
+
+	if s.Unreachable {
+		events = append(events, healthEventRecovered)
+	}
+
+	if !s.Uptime.IsZero() && uptime.After(s.Uptime) {
+		events = append(events, healthEventRestarted)
+	}
+
+	return healthState{Uptime: uptime}, events
+}
+
+// runHealthChecks checks the status of every Alertmanager with health checks enabled. It runs as
+// a cluster job, so only one node posts.
+func (p *Plugin) runHealthChecks() {
+	configuration := p.getConfiguration()
+	now := time.Now()
+
+	for _, alertConfig := range configuration.AlertConfigs {
+		if alertConfig.HealthCheckFailures <= 0 {
+			continue
+		}
+
+		key := healthStateKeyPrefix + alertConfig.ID
+		var state healthState
+		if err := p.client.KV.Get(key, &state); err != nil {
+			p.API.LogWarn("Failed to get health state", "config", alertConfig.ID, "error", err.Error())
+			continue
+		}
+
+		status, err := alertmanager.Status(alertConfig.AlertManagerURL)
+		previous := state
+		state, events := state.next(status.Uptime, err, alertConfig.HealthCheckFailures, now)
+
+		for _, event := range events {
+			p.postHealthEvent(alertConfig, event, previous, state, now)
+		}
+
+		if _, err := p.client.KV.Set(key, state); err != nil {
+			p.API.LogWarn("Failed to save health state", "config", alertConfig.ID, "error", err.Error())
+		}
+	}
+}
+
+func (p *Plugin) postHealthEvent(config alertConfig, event healthEvent, previous, state healthState, now time.Time) {
+	var attachment *model.SlackAttachment
+	switch event {
+	case healthEventUnreachable:
+		attachment = &model.SlackAttachment{
+			Title: fmt.Sprintf(":rotating_light: AlertManager %s unreachable since %s", config.ID, formatTime(config, state.FailingSince)),
+			Text:  fmt.Sprintf("%d consecutive health checks of %s failed: %s", state.ConsecutiveFailures, config.AlertManagerURL, state.LastError),
+			Color: colorFiring,
+		}
+	case healthEventRecovered:
+		attachment = &model.SlackAttachment{
+			Title: fmt.Sprintf(":white_check_mark: AlertManager %s recovered", config.ID),
+			Text: fmt.Sprintf("%s is reachable again after %s.", config.AlertManagerURL,
+				durafmt.Parse(now.Sub(previous.FailingSince)).LimitFirstN(2).String(),
+			),
+			Color: colorResolved,
+		}
+	case healthEventRestarted:
+		attachment = &model.SlackAttachment{
+			Title: fmt.Sprintf(":arrows_counterclockwise: AlertManager %s restarted", config.ID),
+			Text:  fmt.Sprintf("%s is up since %s.", config.AlertManagerURL, formatTime(config, state.Uptime)),
+			Color: colorExpired,
+		}
+	default:
+		return
+	}
+
+	channelID, err := p.getHealthChannelID(config)
+	if err != nil {
+		p.API.LogError("Failed to get health check channel", "config", config.ID, "error", err.Error())
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: channelID,
+		UserId:    p.BotUserID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogError("Failed to post health event", "config", config.ID, "error", appErr.Error())
+	}
+}
+
+// getHealthChannelID returns the channel health events of a config are posted to: its
+// HealthCheckChannel in the config's team if set, or the alert channel.
+func (p *Plugin) getHealthChannelID(config alertConfig) (string, error) {
+	if config.HealthCheckChannel == "" {
+		return p.getAlertChannelID(config.ID), nil
+	}
+
+	team, appErr := p.API.GetTeamByName(config.Team)
+	if appErr != nil {
+		return "", fmt.Errorf("failed to get team: %w", appErr)
+	}
+
+	channel, appErr := p.API.GetChannelByName(team.Id, config.HealthCheckChannel, false)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			p.API.LogWarn("Health check channel not found, using the alert channel", "config", config.ID, "channel", config.HealthCheckChannel)
+			return p.getAlertChannelID(config.ID), nil
+		}
+		return "", fmt.Errorf("failed to get health check channel: %w", appErr)
+	}
+
+	return channel.Id, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthStateNext(t *testing.T) {
+	now := time.Now()
+	uptime := now.Add(-time.Hour)
+	errDown := errors.New("connection refused")
+
+	state, events := healthState{}.next(uptime, nil, 3, now)
+	assert.Empty(t, events)
+	assert.Equal(t, uptime, state.Uptime)
+
+	state, events = state.next(time.Time{}, errDown, 3, now)
+	assert.Empty(t, events)
+	assert.Equal(t, now, state.FailingSince)
+
+	state, events = state.next(time.Time{}, errDown, 3, now.Add(time.Minute))
+	assert.Empty(t, events)
+
+	state, events = state.next(time.Time{}, errDown, 3, now.Add(2*time.Minute))
+	assert.Equal(t, []healthEvent{healthEventUnreachable}, events)
+	assert.True(t, state.Unreachable)
+	assert.Equal(t, now, state.FailingSince)
+	assert.Equal(t, uptime, state.Uptime)
+
+	state, events = state.next(time.Time{}, errDown, 3, now.Add(3*time.Minute))
+	assert.Empty(t, events)
+
+	restarted := now.Add(3 * time.Minute)
+	state, events = state.next(restarted, nil, 3, now.Add(4*time.Minute))
+	assert.Equal(t, []healthEvent{healthEventRecovered, healthEventRestarted}, events)
+	assert.Equal(t, healthState{Uptime: restarted}, state)
+
+	_, events = state.next(restarted, nil, 3, now.Add(5*time.Minute))
+	assert.Empty(t, events)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	heartbeatJobKey         = "heartbeat"
+	heartbeatJobInterval    = time.Minute
+	heartbeatStateKeyPrefix = "heartbeat_"
+)
+
+type heartbeatEvent int
+
+const (
+	heartbeatEventNone heartbeatEvent = iota
+	heartbeatEventBroken
+	heartbeatEventRecovered
+)
+
+// heartbeatState tracks the always-firing heartbeat alert of an alerting pipeline.
+type heartbeatState struct {
+	LastSeen time.Time
+	Broken   bool
+}
+
+// next returns the state of the heartbeat at now, and the event to report if the pipeline broke
+// or recovered. A heartbeat never seen starts its timeout at now.
+func (s heartbeatState) next(timeout time.Duration, now time.Time) (heartbeatState, heartbeatEvent) {
+	if s.LastSeen.IsZero() {
+		s.LastSeen = now
+	}
+
+	expired := now.Sub(s.LastSeen) > timeout
+	switch {
+	case expired && !s.Broken:
+		s.Broken = true
+		return s, heartbeatEventBroken
+	case !expired && s.Broken:
+		s.Broken = false
+		return s, heartbeatEventRecovered
+	}
+
+	return s, heartbeatEventNone
+}
+
+// filterHeartbeats swallows the alerts matching the HeartbeatMatcher of the config, refreshing
+// the heartbeat instead. It returns false if no alerts are left to post.
+func (p *Plugin) filterHeartbeats(config alertConfig, message webhook.Message) (webhook.Message, bool) {
+	if config.HeartbeatMatcher == "" {
+		return message, true
+	}
+
+	matchers, err := parseMatchers(config.HeartbeatMatcher)
+	if err != nil {
+		p.API.LogWarn("Invalid heartbeat matcher", "config", config.ID, "error", err.Error())
+		return message, true
+	}
+
+	var alerts template.Alerts
+	var heartbeat bool
+	for _, alert := range message.Alerts {
+		if !matchAlert(matchers, alert) {
+			alerts = append(alerts, alert)
+			continue
+		}
+
+		if alert.Status == string(prommodel.AlertFiring) {
+			heartbeat = true
+		}
+	}
+
+	if heartbeat {
+		p.refreshHeartbeat(config)
+	}
+
+	if len(alerts) == len(message.Alerts) {
+		return message, true
+	}
+	if len(alerts) == 0 {
+		return message, false
+	}
+
+	return withAlerts(message, alerts), true
+}
+
+func (p *Plugin) refreshHeartbeat(config alertConfig) {
+	err := p.updateHeartbeat(config.ID, func(state heartbeatState) heartbeatState {
+		state.LastSeen = time.Now()
+		return state
+	})
+	if err != nil {
+		p.API.LogError("Failed to refresh heartbeat", "config", config.ID, "error", err.Error())
+	}
+}
+
+func (p *Plugin) updateHeartbeat(configID string, fn func(state heartbeatState) heartbeatState) error {
+	return p.updateKV(heartbeatStateKeyPrefix+configID, func(oldValue []byte) (interface{}, error) {
+		var state heartbeatState
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &state); err != nil {
+				return nil, err
+			}
+		}
+
+		return fn(state), nil
+	})
+}
+
+// runHeartbeatChecks reports alerting pipelines whose heartbeat stopped or came back. It runs as
+// a cluster job, so only one node posts.
+func (p *Plugin) runHeartbeatChecks() {
+	configuration := p.getConfiguration()
+	now := time.Now()
+
+	for _, alertConfig := range configuration.AlertConfigs {
+		if alertConfig.HeartbeatMatcher == "" || alertConfig.HeartbeatTimeout <= 0 {
+			continue
+		}
+		timeout := time.Duration(alertConfig.HeartbeatTimeout) * time.Minute
+
+		var event heartbeatEvent
+		var state heartbeatState
+		err := p.updateHeartbeat(alertConfig.ID, func(oldState heartbeatState) heartbeatState {
+			state, event = oldState.next(timeout, now)
+			return state
+		})
+		if err != nil {
+			p.API.LogError("Failed to check heartbeat", "config", alertConfig.ID, "error", err.Error())
+			continue
+		}
+
+		if event != heartbeatEventNone {
+			p.postHeartbeatEvent(alertConfig, event, state, now)
+		}
+	}
+}
+
+func (p *Plugin) postHeartbeatEvent(config alertConfig, event heartbeatEvent, state heartbeatState, now time.Time) {
+	silentFor := durafmt.Parse(now.Sub(state.LastSeen)).LimitFirstN(2).String()
+
+	var message string
+	var attachment *model.SlackAttachment
+	switch event {
+	case heartbeatEventBroken:
+		message = "@channel"
+		attachment = &model.SlackAttachment{
+			Title: ":skull: Alerting pipeline is broken",
+			Text: fmt.Sprintf("No heartbeat from AlertManager %s for %s (matcher `%s`). Alerts may not be delivered.",
+				config.ID, silentFor, config.HeartbeatMatcher),
+			Color: colorFiring,
+		}
+	case heartbeatEventRecovered:
+		attachment = &model.SlackAttachment{
+			Title: ":heartbeat: Alerting pipeline recovered",
+			Text:  fmt.Sprintf("Heartbeat from AlertManager %s received again.", config.ID),
+			Color: colorResolved,
+		}
+	default:
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: p.getAlertChannelID(config.ID),
+		UserId:    p.BotUserID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogError("Failed to post heartbeat event", "config", config.ID, "error", appErr.Error())
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatStateNext(t *testing.T) {
+	now := time.Now()
+	timeout := 10 * time.Minute
+
+	state, event := heartbeatState{}.next(timeout, now)
+	assert.Equal(t, heartbeatEventNone, event)
+	assert.Equal(t, now, state.LastSeen)
+
+	state, event = state.next(timeout, now.Add(timeout))
+	assert.Equal(t, heartbeatEventNone, event)
+
+	state, event = state.next(timeout, now.Add(timeout+time.Minute))
+	assert.Equal(t, heartbeatEventBroken, event)
+	assert.True(t, state.Broken)
+
+	state, event = state.next(timeout, now.Add(timeout+2*time.Minute))
+	assert.Equal(t, heartbeatEventNone, event)
+
+	state.LastSeen = now.Add(timeout + 3*time.Minute)
+	state, event = state.next(timeout, now.Add(timeout+4*time.Minute))
+	assert.Equal(t, heartbeatEventRecovered, event)
+	assert.False(t, state.Broken)
+}
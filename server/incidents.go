@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	incidentKeyPrefix = "incident_"
+
+	incidentJobKey      = "incidents"
+	incidentJobInterval = time.Minute
+
+	// incidentClaimTimeout is how long a delivery may take to open an incident channel before
+	// another delivery of the group takes over.
+	incidentClaimTimeout = time.Minute
+
+	defaultIncidentChannelTemplate = "inc-{{alertname}}-{{date}}"
+
+	// maxIncidentChannelNameLength leaves room in the channel name for the suffix of
+	// incidentChannelAttempts.
+	maxIncidentChannelNameLength = model.ChannelNameMaxLength - 4
+	incidentChannelAttempts      = 20
+)
+
+var (
+	incidentTemplateField   = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+	invalidChannelNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+	repeatedDashes          = regexp.MustCompile(`-{2,}`)
+)
+
+// incident is the channel opened for an alert group matching the IncidentMatcher of its config.
+type incident struct {
+	ConfigID string
+	GroupKey string
+	// ChannelID is empty while the channel is being opened.
+	ChannelID   string
+	ChannelName string
+	CreatedAt   time.Time
+	// ResolvedAt is when the group resolved, zero while it fires.
+	ResolvedAt time.Time
+}
+
+func incidentKey(configID, groupKey string) string {
+	hash := sha256.Sum256([]byte(groupKey))
+	return fmt.Sprintf("%s%s_%s", incidentKeyPrefix, configID, hex.EncodeToString(hash[:16]))
+}
+
+// incidentChannelName renders an incident channel name template with the labels common to the
+// alerts of a group and the date, as a valid channel name.
+func incidentChannelName(tmpl string, labels template.KV, now time.Time) string {
+	if tmpl == "" {
+		tmpl = defaultIncidentChannelTemplate
+	}
+
+	name := incidentTemplateField.ReplaceAllStringFunc(tmpl, func(field string) string {
+		key := incidentTemplateField.FindStringSubmatch(field)[1]
+		if key == "date" {
+			return now.Format("20060102")
+		}
+		return labels[key]
+	})
+
+	name = invalidChannelNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = repeatedDashes.ReplaceAllString(name, "-")
+	if len(name) > maxIncidentChannelNameLength {
+		name = name[:maxIncidentChannelNameLength]
+	}
+	name = strings.Trim(name, "-_")
+	if name == "" {
+		return "incident"
+	}
+
+	return name
+}
+
+// matchesIncident reports whether a firing alert of a group matches the IncidentMatcher of the
+// config.
+func matchesIncident(config alertConfig, message webhook.Message) bool {
+	matchers, err := parseMatchers(config.IncidentMatcher)
+	if err != nil {
+		return false
+	}
+
+	for _, alert := range message.Alerts.Firing() {
+		if matchAlert(matchers, alert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postToIncident posts a notification to the incident channel of its alert group, unless the
+// delivery already did, opening the incident first if the group matches the IncidentMatcher of
+// the config.
+func (p *Plugin) postToIncident(config alertConfig, d *delivery, message webhook.Message) error {
+	if config.IncidentMatcher == "" {
+		return nil
+	}
+
+	key := incidentKey(config.ID, message.GroupKey)
+	var inc *incident
+	if err := p.client.KV.Get(key, &inc); err != nil {
+		return fmt.Errorf("failed to get incident: %w", err)
+	}
+
+	if inc == nil || inc.ChannelID == "" {
+		if message.Status != string(prommodel.AlertFiring) || !matchesIncident(config, message) {
+			return nil
+		}
+
+		var err error
+		if inc, err = p.openIncident(config, key, message); err != nil {
+			return fmt.Errorf("failed to open incident channel: %w", err)
+		}
+	}
+
+	if err := p.postOnce(config, d, inc.ChannelID, message); err != nil {
+		return fmt.Errorf("failed to post to incident channel %s: %w", inc.ChannelName, err)
+	}
+
+	resolved := message.Status == string(prommodel.AlertResolved)
+	if resolved == !inc.ResolvedAt.IsZero() {
+		return nil
+	}
+
+	err := p.updateKV(key, func(oldValue []byte) (interface{}, error) {
+		if len(oldValue) == 0 {
+			return nil, errKVUnchanged
+		}
+
+		var stored incident
+		if err := json.Unmarshal(oldValue, &stored); err != nil {
+			return nil, err
+		}
+
+		if !resolved {
+			stored.ResolvedAt = time.Time{}
+			return &stored, nil
+		}
+
+		// Without archiving, the incident ends with the group, and the next firing opens a new one.
+		if config.IncidentArchiveAfter <= 0 {
+			return nil, nil
+		}
+		stored.ResolvedAt = time.Now()
+		return &stored, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update incident: %w", err)
+	}
+
+	if resolved && config.IncidentArchiveAfter > 0 {
+		p.postIncidentMessage(inc.ChannelID, fmt.Sprintf("The alert group resolved. This channel is archived in %d minutes unless it fires again.", config.IncidentArchiveAfter))
+	}
+
+	return nil
+}
+
+// claimIncident atomically claims the key of an incident to open, so that concurrent deliveries
+// of an alert group open a single channel. A claim not completed within incidentClaimTimeout is
+// taken over.
+func (p *Plugin) claimIncident(config alertConfig, key, groupKey string, now time.Time) (bool, error) {
+	claimed := false
+	err := p.updateKV(key, func(oldValue []byte) (interface{}, error) {
+		claimed = false
+		if len(oldValue) > 0 {
+			var stored incident
+			if err := json.Unmarshal(oldValue, &stored); err != nil {
+				return nil, err
+			}
+			if stored.ChannelID != "" || now.Sub(stored.CreatedAt) < incidentClaimTimeout {
+				return nil, errKVUnchanged
+			}
+		}
+
+		claimed = true
+		return &incident{ConfigID: config.ID, GroupKey: groupKey, CreatedAt: now}, nil
+	})
+
+	return claimed, err
+}
+
+// openIncident creates the incident channel of an alert group, adds the responders of the config
+// to it, and links it from the channel of the config. It fails if another delivery of the group
+// is opening the incident.
+func (p *Plugin) openIncident(config alertConfig, key string, message webhook.Message) (*incident, error) {
+	now := time.Now()
+	claimed, err := p.claimIncident(config, key, message.GroupKey, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim incident: %w", err)
+	}
+	if !claimed {
+		return nil, fmt.Errorf("incident of group %s is being opened", message.GroupKey)
+	}
+
+	inc, err := p.createIncident(config, key, message, now)
+	if err != nil {
+		if deleteErr := p.client.KV.Delete(key); deleteErr != nil {
+			p.API.LogError("Failed to release incident claim", "config", config.ID, "error", deleteErr.Error())
+		}
+		return nil, err
+	}
+
+	for responder := range splitList(config.IncidentResponders) {
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(responder, "@"))
+		if appErr != nil {
+			p.API.LogWarn("Incident responder not found", "config", config.ID, "responder", responder, "error", appErr.Error())
+			continue
+		}
+		if _, appErr = p.API.AddChannelMember(inc.ChannelID, user.Id); appErr != nil {
+			p.API.LogWarn("Failed to add incident responder", "config", config.ID, "responder", responder, "error", appErr.Error())
+		}
+	}
+
+	p.postIncidentMessage(p.getAlertChannelID(config.ID), fmt.Sprintf(":rotating_light: Opened the incident channel ~%s for %s.", inc.ChannelName, incidentDisplayName(message, inc.ChannelName)))
+
+	return inc, nil
+}
+
+// incidentDisplayName returns the display name of the incident channel of an alert group.
+func incidentDisplayName(message webhook.Message, name string) string {
+	displayName := name
+	if alertName := message.CommonLabels[prommodel.AlertNameLabel]; alertName != "" {
+		displayName = "Incident: " + alertName
+	}
+	if runes := []rune(displayName); len(runes) > model.ChannelDisplayNameMaxRunes {
+		displayName = string(runes[:model.ChannelDisplayNameMaxRunes])
+	}
+
+	return displayName
+}
+
+// createIncident creates the channel of a claimed incident and saves the incident.
+func (p *Plugin) createIncident(config alertConfig, key string, message webhook.Message, now time.Time) (*incident, error) {
+	team, appErr := p.API.GetTeamByName(config.Team)
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to get team: %w", appErr)
+	}
+
+	name := incidentChannelName(config.IncidentChannelTemplate, message.CommonLabels, now)
+	channel, err := p.createIncidentChannel(config, team.Id, name, incidentDisplayName(message, name))
+	if err != nil {
+		return nil, err
+	}
+
+	inc := &incident{
+		ConfigID:    config.ID,
+		GroupKey:    message.GroupKey,
+		ChannelID:   channel.Id,
+		ChannelName: channel.Name,
+		CreatedAt:   now,
+	}
+	if _, err = p.client.KV.Set(key, inc); err != nil {
+		if appErr := p.API.DeleteChannel(channel.Id); appErr != nil {
+			p.API.LogError("Failed to archive unsaved incident channel", "channel", channel.Name, "error", appErr.Error())
+		}
+		return nil, fmt.Errorf("failed to save incident: %w", err)
+	}
+
+	return inc, nil
+}
+
+// createIncidentChannel creates a channel with the given name, or with a numbered suffix if the
+// name is taken. It is private like the alert channel if the config creates private channels, and
+// the bot is added to it then.
+func (p *Plugin) createIncidentChannel(config alertConfig, teamID, name, displayName string) (*model.Channel, error) {
+	for attempt := 1; attempt <= incidentChannelAttempts; attempt++ {
+		candidate := name
+		if attempt > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, attempt)
+		}
+
+		if _, appErr := p.API.GetChannelByName(teamID, candidate, true); appErr == nil {
+			continue
+		}
+
+		channel, appErr := p.API.CreateChannel(&model.Channel{
+			Name:        candidate,
+			DisplayName: displayName,
+			Type:        alertChannelType(config),
+			TeamId:      teamID,
+			CreatorId:   p.BotUserID,
+		})
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to create incident channel: %w", appErr)
+		}
+
+		if channel.Type == model.ChannelTypePrivate {
+			if _, appErr = p.API.AddChannelMember(channel.Id, p.BotUserID); appErr != nil {
+				p.API.LogWarn("Failed to add the bot to the incident channel", "channel", channel.Name, "error", appErr.Error())
+			}
+		}
+
+		return channel, nil
+	}
+
+	return nil, fmt.Errorf("incident channel names %s to %s-%d are taken", name, name, incidentChannelAttempts)
+}
+
+func (p *Plugin) postIncidentMessage(channelID, message string) {
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: channelID,
+		Message:   message,
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogError("Failed to post incident message", "channel", channelID, "error", appErr.Error())
+	}
+}
+
+// runIncidentArchival archives the incident channels of the groups resolved for longer than the
+// IncidentArchiveAfter of their config.
+func (p *Plugin) runIncidentArchival() {
+	keys, err := p.listKeys(incidentKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list incidents", "error", err.Error())
+		return
+	}
+
+	configuration := p.getConfiguration()
+	now := time.Now()
+	for _, key := range keys {
+		var archive *incident
+		err := p.updateKV(key, func(oldValue []byte) (interface{}, error) {
+			archive = nil
+			if len(oldValue) == 0 {
+				return nil, errKVUnchanged
+			}
+
+			var inc incident
+			if err := json.Unmarshal(oldValue, &inc); err != nil {
+				return nil, err
+			}
+
+			config, ok := configuration.AlertConfigs[inc.ConfigID]
+			if !ok || config.IncidentMatcher == "" {
+				// The incidents of removed configs are forgotten, their channels are kept.
+				return nil, nil
+			}
+
+			if inc.ResolvedAt.IsZero() {
+				return nil, errKVUnchanged
+			}
+
+			// Archiving was disabled since the group resolved, which ends the incident.
+			if config.IncidentArchiveAfter <= 0 {
+				return nil, nil
+			}
+
+			if now.Sub(inc.ResolvedAt) < time.Duration(config.IncidentArchiveAfter)*time.Minute {
+				return nil, errKVUnchanged
+			}
+
+			archive = &inc
+			return nil, nil
+		})
+		if err != nil {
+			p.API.LogError("Failed to check incident", "key", key, "error", err.Error())
+			continue
+		}
+
+		if archive != nil {
+			if appErr := p.API.DeleteChannel(archive.ChannelID); appErr != nil {
+				p.API.LogError("Failed to archive incident channel", "channel", archive.ChannelName, "error", appErr.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncidentChannelName(t *testing.T) {
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	labels := template.KV{"alertname": "CheckoutErrors", "service": "Payments API"}
+
+	assert.Equal(t, "inc-checkouterrors-20230601", incidentChannelName("", labels, now))
+	assert.Equal(t, "payments-api-checkouterrors", incidentChannelName("{{ service }}-{{alertname}}", labels, now))
+	assert.Equal(t, "inc-20230601", incidentChannelName("inc-{{missing}}-{{date}}", labels, now))
+	assert.Equal(t, "incident", incidentChannelName("{{missing}}", labels, now))
+
+	long := incidentChannelName("{{alertname}}", template.KV{"alertname": strings.Repeat("x", 100)}, now)
+	assert.Len(t, long, maxIncidentChannelNameLength)
+}
+
+func TestMatchesIncident(t *testing.T) {
+	config := alertConfig{IncidentMatcher: `severity="critical"`}
+	message := func(alerts ...template.Alert) webhook.Message {
+		return webhook.Message{Data: &template.Data{Alerts: alerts}}
+	}
+	critical := template.Alert{Status: "firing", Labels: template.KV{"alertname": "CheckoutErrors", "severity": "critical"}}
+	warning := template.Alert{Status: "firing", Labels: template.KV{"alertname": "CheckoutSlow", "severity": "warning"}}
+	resolved := template.Alert{Status: "resolved", Labels: critical.Labels}
+
+	assert.True(t, matchesIncident(config, message(warning, critical)))
+	assert.False(t, matchesIncident(config, message(warning)))
+	assert.False(t, matchesIncident(config, message(resolved)))
+}
+
+func TestIncidentKey(t *testing.T) {
+	key := incidentKey("0", `{}:{alertname="CheckoutErrors"}`)
+	assert.True(t, strings.HasPrefix(key, "incident_0_"))
+	assert.Equal(t, key, incidentKey("0", `{}:{alertname="CheckoutErrors"}`))
+	assert.NotEqual(t, key, incidentKey("1", `{}:{alertname="CheckoutErrors"}`))
+}
+
+// newIncidentTestPlugin returns a plugin opening incidents for the critical alerts of config 0,
+// whose channels are created in the team "team".
+func newIncidentTestPlugin(t *testing.T) (*Plugin, *plugintest.API, alertConfig) {
+	p, api, _ := newTestPlugin(t)
+	api.On("GetTeamByName", "team").Return(&model.Team{Id: "team-id", Name: "team"}, nil)
+	api.On("GetChannelByName", "team-id", mock.AnythingOfType("string"), true).Return(nil, model.NewAppError("GetChannelByName", "test.channel", nil, "", http.StatusNotFound))
+	api.On("CreateChannel", mock.AnythingOfType("*model.Channel")).Return(func(channel *model.Channel) *model.Channel {
+		created := *channel
+		created.Id = channel.Name + "-id"
+		return &created
+	}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+	api.On("DeleteChannel", mock.AnythingOfType("string")).Return(nil)
+
+	config := alertConfig{ID: "0", Team: "team", IncidentMatcher: `severity="critical"`, IncidentArchiveAfter: 30}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+
+	return p, api, config
+}
+
+func incidentMessage(status string) webhook.Message {
+	alert := template.Alert{Status: status, Labels: template.KV{"alertname": "CheckoutErrors", "severity": "critical"}, StartsAt: time.Now().Add(-time.Minute)}
+	if status == "resolved" {
+		alert.EndsAt = time.Now()
+	}
+
+	return newWebhookMessage("mattermost", "{}:{alertname=\"CheckoutErrors\"}", "http://alertmanager:9093", template.KV{"alertname": "CheckoutErrors"}, template.Alerts{alert})
+}
+
+func getIncident(t *testing.T, p *Plugin, key string) *incident {
+	var inc *incident
+	require.NoError(t, p.client.KV.Get(key, &inc))
+	return inc
+}
+
+func TestIncidentLifecycle(t *testing.T) {
+	p, api, config := newIncidentTestPlugin(t)
+	key := incidentKey("0", incidentMessage("firing").GroupKey)
+	name := incidentChannelName("", template.KV{"alertname": "CheckoutErrors", "severity": "critical"}, time.Now())
+
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	inc := getIncident(t, p, key)
+	require.NotNil(t, inc)
+	assert.Equal(t, name+"-id", inc.ChannelID)
+	assert.True(t, inc.ResolvedAt.IsZero())
+	assert.Equal(t, []string{"alerts-id", name + "-id"}, postedChannels(api))
+
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("resolved")))
+	inc = getIncident(t, p, key)
+	require.NotNil(t, inc)
+	assert.False(t, inc.ResolvedAt.IsZero())
+
+	// The channel is kept until the group is resolved for IncidentArchiveAfter minutes.
+	p.runIncidentArchival()
+	api.AssertNotCalled(t, "DeleteChannel", mock.Anything)
+
+	inc.ResolvedAt = time.Now().Add(-31 * time.Minute)
+	_, err := p.client.KV.Set(key, inc)
+	require.NoError(t, err)
+	p.runIncidentArchival()
+	api.AssertCalled(t, "DeleteChannel", name+"-id")
+	assert.Nil(t, getIncident(t, p, key))
+	api.AssertNumberOfCalls(t, "CreateChannel", 1)
+}
+
+func TestIncidentRefire(t *testing.T) {
+	p, api, config := newIncidentTestPlugin(t)
+	key := incidentKey("0", incidentMessage("firing").GroupKey)
+
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("resolved")))
+	require.False(t, getIncident(t, p, key).ResolvedAt.IsZero())
+
+	// Firing again before the channel is archived continues the incident in the same channel.
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	inc := getIncident(t, p, key)
+	require.NotNil(t, inc)
+	assert.True(t, inc.ResolvedAt.IsZero())
+	api.AssertNumberOfCalls(t, "CreateChannel", 1)
+
+	p.runIncidentArchival()
+	api.AssertNotCalled(t, "DeleteChannel", mock.Anything)
+}
+
+func TestIncidentClaim(t *testing.T) {
+	p, api, config := newIncidentTestPlugin(t)
+	key := incidentKey("0", incidentMessage("firing").GroupKey)
+
+	// Another delivery of the group is opening the incident.
+	claimed, err := p.claimIncident(config, key, incidentMessage("firing").GroupKey, time.Now())
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	assert.Error(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	api.AssertNotCalled(t, "CreateChannel", mock.Anything)
+
+	// A claim that was not completed in time is taken over.
+	claimed, err = p.claimIncident(config, key, incidentMessage("firing").GroupKey, time.Now().Add(incidentClaimTimeout))
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestIncidentArchivalDisabled(t *testing.T) {
+	p, api, config := newIncidentTestPlugin(t)
+	key := incidentKey("0", incidentMessage("firing").GroupKey)
+
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("resolved")))
+	inc := getIncident(t, p, key)
+	inc.ResolvedAt = time.Now().Add(-time.Hour)
+	_, err := p.client.KV.Set(key, inc)
+	require.NoError(t, err)
+
+	// Archiving was disabled after the group resolved, so the channel is kept.
+	config.IncidentArchiveAfter = 0
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.runIncidentArchival()
+	api.AssertNotCalled(t, "DeleteChannel", mock.Anything)
+	assert.Nil(t, getIncident(t, p, key))
+}
+
+func TestIncidentPrivateChannel(t *testing.T) {
+	p, api, config := newIncidentTestPlugin(t)
+	api.On("AddChannelMember", mock.AnythingOfType("string"), "bot-id").Return(&model.ChannelMember{}, nil)
+	p.BotUserID = "bot-id"
+
+	config.ChannelType = channelTypePrivate
+	require.NoError(t, p.postToIncident(config, &delivery{ID: model.NewId()}, incidentMessage("firing")))
+	api.AssertCalled(t, "CreateChannel", mock.MatchedBy(func(channel *model.Channel) bool {
+		return channel.Type == model.ChannelTypePrivate
+	}))
+	api.AssertCalled(t, "AddChannelMember", getIncident(t, p, incidentKey("0", incidentMessage("firing").GroupKey)).ChannelID, "bot-id")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+)
+
+// scheduleJob starts a cluster-wide job running callback on the given interval. The job is
+// guarded by a cluster mutex, so only one plugin instance runs it at a time. Scheduling a key
+// that is already running is a no-op, which keeps repeated OnActivate calls safe.
+func (p *Plugin) scheduleJob(key string, interval time.Duration, callback func()) error {
+	p.jobsLock.Lock()
+	defer p.jobsLock.Unlock()
+
+	if _, ok := p.jobs[key]; ok {
+		return nil
+	}
+
+	job, err := cluster.Schedule(p.API, key, cluster.MakeWaitForRoundedInterval(interval), callback)
+	if err != nil {
+		return fmt.Errorf("failed to schedule job %q: %w", key, err)
+	}
+
+	if p.jobs == nil {
+		p.jobs = make(map[string]*cluster.Job)
+	}
+	p.jobs[key] = job
+
+	return nil
+}
+
+// closeJobs stops all jobs scheduled by this plugin instance.
+func (p *Plugin) closeJobs() {
+	p.jobsLock.Lock()
+	defer p.jobsLock.Unlock()
+
+	for key, job := range p.jobs {
+		if err := job.Close(); err != nil {
+			p.API.LogWarn("Failed to close job", "job", key, "error", err.Error())
+		}
+	}
+	p.jobs = nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// listKeysPerPage is the page size used by listKeys.
+const listKeysPerPage = 100
+
+// errKVUnchanged is returned by an updateKV callback to leave the stored value untouched.
+var errKVUnchanged = errors.New("kv value unchanged")
+
+// updateKV atomically replaces the value stored under key with the one returned by fn, retrying
+// on concurrent writes from other nodes. A nil value deletes the key.
+func (p *Plugin) updateKV(key string, fn func(oldValue []byte) (interface{}, error)) error {
+	err := p.client.KV.SetAtomicWithRetries(key, fn)
+	if errors.Is(err, errKVUnchanged) {
+		return nil
+	}
+
+	return err
+}
+
+// listKeys returns all keys starting with prefix. The pages of keys are filtered here rather
+// than with pluginapi.WithPrefix, which filters each page and so shortens it, ending the listing
+// early.
+func (p *Plugin) listKeys(prefix string) ([]string, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, err := p.client.KV.ListKeys(page, listKeysPerPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		if len(pageKeys) < listKeysPerPage {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testKVStore is an in-memory plugin KV store backing the KV calls of a plugintest.API.
+type testKVStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	// failSet makes the writes of the keys it returns true for fail.
+	failSet func(key string) bool
+}
+
+// mockKVStore backs the KV calls of api with an in-memory store, honoring atomic sets.
+func mockKVStore(api *plugintest.API) *testKVStore {
+	s := &testKVStore{values: make(map[string][]byte)}
+
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		return s.get(key)
+	}, nil)
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failSet != nil && s.failSet(key) {
+			return false
+		}
+		if options.Atomic && !bytes.Equal(s.values[key], options.OldValue) {
+			return false
+		}
+		if value == nil {
+			delete(s.values, key)
+		} else {
+			s.values[key] = value
+		}
+		return true
+	}, func(key string, _ []byte, _ model.PluginKVSetOptions) *model.AppError {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failSet != nil && s.failSet(key) {
+			return model.NewAppError("KVSetWithOptions", "test.kv.set", nil, "failed to set "+key, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	api.On("KVList", mock.AnythingOfType("int"), mock.AnythingOfType("int")).Return(func(page, perPage int) []string {
+		keys := s.keys()
+		start, end := page*perPage, (page+1)*perPage
+		if start > len(keys) {
+			start = len(keys)
+		}
+		if end > len(keys) {
+			end = len(keys)
+		}
+		return keys[start:end]
+	}, nil)
+
+	return s
+}
+
+func (s *testKVStore) get(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.values[key]
+}
+
+func (s *testKVStore) set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+}
+
+func (s *testKVStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestListKeys(t *testing.T) {
+	api := &plugintest.API{}
+	mockKVStore(api)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+
+	var want []string
+	for i := 0; i < 2*listKeysPerPage+5; i++ {
+		key := model.NewId()
+		if i%2 == 0 {
+			key = "list_" + key
+			want = append(want, key)
+		}
+		_, err := p.client.KV.Set(key, "value")
+		require.NoError(t, err)
+	}
+	sort.Strings(want)
+
+	keys, err := p.listKeys("list_")
+	require.NoError(t, err)
+	assert.Equal(t, want, keys)
+}
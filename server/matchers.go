@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
+)
+
+// parseMatchers parses Alertmanager matchers such as `alertname="Watchdog"` or
+// `{severity="critical",team=~"db|infra"}`.
+func parseMatchers(s string) (labels.Matchers, error) {
+	return labels.ParseMatchers(s)
+}
+
+// matchAlert reports whether the labels of alert satisfy all matchers.
+func matchAlert(matchers labels.Matchers, alert template.Alert) bool {
+	labelSet := make(prommodel.LabelSet, len(alert.Labels))
+	for k, v := range alert.Labels {
+		labelSet[prommodel.LabelName(k)] = prommodel.LabelValue(v)
+	}
+
+	return matchers.Matches(labelSet)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const metricsNamespace = "mattermost_plugin_alertmanager"
+
+// metrics are the Prometheus metrics of the plugin, served on /plugins/alertmanager/metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	webhooksReceived *prometheus.CounterVec
+	webhookErrors    *prometheus.CounterVec
+	webhookDuration  *prometheus.HistogramVec
+	postsCreated     *prometheus.CounterVec
+	postsFailed      *prometheus.CounterVec
+	actions          *prometheus.CounterVec
+}
+
+func newMetrics() (*metrics, error) {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		webhooksReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhooks_received_total",
+			Help:      "Number of webhook notifications received, by alert config and status.",
+		}, []string{"config", "status"}),
+		webhookErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhook_errors_total",
+			Help:      "Number of rejected webhook notifications, by alert config and reason.",
+		}, []string{"config", "reason"}),
+		webhookDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhook_duration_seconds",
+			Help:      "Duration of the handling of webhook notifications, by alert config.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"config"}),
+		postsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "posts_created_total",
+			Help:      "Number of alert posts created, by alert config.",
+		}, []string{"config"}),
+		postsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "posts_failed_total",
+			Help:      "Number of alert posts that failed to be created, by alert config.",
+		}, []string{"config"}),
+		actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "actions_total",
+			Help:      "Number of action button invocations, by action.",
+		}, []string{"action"}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		m.webhooksReceived, m.webhookErrors, m.webhookDuration, m.postsCreated, m.postsFailed, m.actions,
+		collectors.NewGoCollector(),
+	} {
+		if err := m.registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := alertmanager.RegisterMetrics(m.registry); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// recordPost counts an alert post that was created, or failed to be if err is not nil.
+func (m *metrics) recordPost(configID string, err error) {
+	if err != nil {
+		m.postsFailed.WithLabelValues(configID).Inc()
+		return
+	}
+
+	m.postsCreated.WithLabelValues(configID).Inc()
+}
+
+// handleMetrics serves the metrics to requests bearing the MetricsToken of the configuration,
+// either as a bearer token or in the token query parameter.
+func (p *Plugin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsToken := p.getConfiguration().MetricsToken
+	if metricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(metricsToken)) != 1 {
+		http.Error(w, "Invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+
+	promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
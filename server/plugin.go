@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin"
 
@@ -26,19 +28,45 @@ type Plugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
-	// key - alert config id, value - existing or created channel id received from api
-	AlertConfigIDChannelID map[string]string
-	BotUserID              string
+	BotUserID string
 
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
+
+	// reloadLock serializes the activation and the configuration changes, which the refreshes of
+	// the alert channels wait for.
+	reloadLock sync.RWMutex
+
+	// alertConfigIDChannelID maps the alert config IDs to the IDs of their channels. Consult
+	// getAlertChannelID and updateAlertChannels for usage.
+	alertConfigIDChannelID map[string]string
+	// alertChannelErrors maps the alert config IDs to the reasons their channels could not be
+	// ensured.
+	alertChannelErrors map[string]string
+	// channelsLock synchronizes access to alertConfigIDChannelID and alertChannelErrors.
+	channelsLock sync.RWMutex
+
+	// rateLimiter limits and aggregates the webhook posts per channel.
+	rateLimiter *channelRateLimiter
+
+	// metrics are served to Prometheus on /metrics.
+	metrics *metrics
+
+	// jobs holds the background jobs scheduled by this plugin instance, keyed by job key.
+	jobs     map[string]*cluster.Job
+	jobsLock sync.Mutex
 }
 
 func (p *Plugin) OnDeactivate() error {
+	p.closeJobs()
+	p.flushAllRateLimited()
 	return nil
 }
 
 func (p *Plugin) OnActivate() error {
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 	botID, err := p.client.Bot.EnsureBot(&model.Bot{
 		Username:    "alertmanagerbot",
@@ -50,18 +78,18 @@ func (p *Plugin) OnActivate() error {
 	}
 	p.BotUserID = botID
 
-	configuration := p.getConfiguration()
-	p.AlertConfigIDChannelID = make(map[string]string)
-	for k, alertConfig := range configuration.AlertConfigs {
-		var channelID string
-		channelID, err = p.ensureAlertChannelExists(alertConfig)
-		if err != nil {
-			p.API.LogWarn(fmt.Sprintf("Failed to ensure alert channel %v", k), "error", err.Error())
-		} else {
-			p.AlertConfigIDChannelID[alertConfig.ID] = channelID
+	if p.rateLimiter == nil {
+		p.rateLimiter = newChannelRateLimiter()
+	}
+
+	if p.metrics == nil {
+		if p.metrics, err = newMetrics(); err != nil {
+			return fmt.Errorf("failed to register metrics: %w", err)
 		}
 	}
 
+	p.updateAlertChannels(&configuration{}, p.getConfiguration())
+
 	command, err := p.getCommand()
 	if err != nil {
 		return fmt.Errorf("failed to get command: %w", err)
@@ -72,44 +100,62 @@ func (p *Plugin) OnActivate() error {
 		return fmt.Errorf("failed to register command: %w", err)
 	}
 
-	return nil
-}
+	if err = p.scheduleJob(digestJobKey, digestJobInterval, p.runDigests); err != nil {
+		return err
+	}
 
-func (p *Plugin) ensureAlertChannelExists(alertConfig alertConfig) (string, error) {
-	if err := alertConfig.IsValid(); err != nil {
-		return "", fmt.Errorf("alert Configuration is invalid: %w", err)
+	if err = p.scheduleJob(reminderJobKey, reminderJobInterval, p.runReminders); err != nil {
+		return err
 	}
 
-	team, appErr := p.API.GetTeamByName(alertConfig.Team)
-	if appErr != nil {
-		return "", fmt.Errorf("failed to get team: %w", appErr)
+	if err = p.scheduleJob(silenceExpiryJobKey, silenceExpiryJobInterval, p.runSilenceExpiryWarnings); err != nil {
+		return err
 	}
 
-	channel, appErr := p.API.GetChannelByName(team.Id, alertConfig.Channel, false)
-	if appErr != nil {
-		if appErr.StatusCode == http.StatusNotFound {
-			channelToCreate := &model.Channel{
-				Name:        alertConfig.Channel,
-				DisplayName: alertConfig.Channel,
-				Type:        model.ChannelTypeOpen,
-				TeamId:      team.Id,
-				CreatorId:   p.BotUserID,
-			}
+	if err = p.scheduleJob(pollerJobKey, pollerJobInterval, p.runPollers); err != nil {
+		return err
+	}
 
-			newChannel, errChannel := p.API.CreateChannel(channelToCreate)
-			if errChannel != nil {
-				return "", fmt.Errorf("failed to create alert channel: %w", errChannel)
-			}
+	if err = p.scheduleJob(healthJobKey, healthJobInterval, p.runHealthChecks); err != nil {
+		return err
+	}
 
-			return newChannel.Id, nil
-		}
-		return "", fmt.Errorf("failed to get existing alert channel: %w", appErr)
+	if err = p.scheduleJob(heartbeatJobKey, heartbeatJobInterval, p.runHeartbeatChecks); err != nil {
+		return err
 	}
 
-	return channel.Id, nil
+	if err = p.scheduleJob(deliveryJobKey, deliveryJobInterval, p.runDeliveries); err != nil {
+		return err
+	}
+
+	if err = p.scheduleJob(flapJobKey, flapJobInterval, p.runFlappingChecks); err != nil {
+		return err
+	}
+
+	if err = p.scheduleJob(incidentJobKey, incidentJobInterval, p.runIncidentArchival); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		p.handleMetrics(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, configsAPIPath) {
+		p.handleConfigsAPI(w, r)
+		return
+	}
+
+	if r.URL.Path == fireAPIPath {
+		p.metrics.actions.WithLabelValues(strings.TrimPrefix(r.URL.Path, "/api/")).Inc()
+		p.handleFireSubmission(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("Mattermost AlertManager Plugin"))
@@ -117,7 +163,7 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	}
 
 	invalidOrMissingTokenErr := "Invalid or missing token"
-	token := r.URL.Query().Get("token")
+	token := requestToken(r)
 	if token == "" {
 		http.Error(w, invalidOrMissingTokenErr, http.StatusBadRequest)
 		return
@@ -126,11 +172,25 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	configuration := p.getConfiguration()
 	for _, alertConfig := range configuration.AlertConfigs {
 		if subtle.ConstantTimeCompare([]byte(token), []byte(alertConfig.Token)) == 1 {
+			if r.URL.Path != "/api/webhook" {
+				p.metrics.actions.WithLabelValues(strings.TrimPrefix(r.URL.Path, "/api/")).Inc()
+			}
+
 			switch r.URL.Path {
 			case "/api/webhook":
 				p.handleWebhook(w, r, alertConfig)
 			case "/api/expire":
 				p.handleExpireAction(w, r, alertConfig)
+			case "/api/acknowledge":
+				p.handleAcknowledgeAction(w, r, alertConfig)
+			case "/api/silence_expiry":
+				p.handleSilenceExpiryAction(w, r, alertConfig)
+			case "/api/silence_flapping":
+				p.handleSilenceFlappingAction(w, r, alertConfig)
+			case "/api/expand":
+				p.handleExpandAction(w, r, alertConfig)
+			case "/api/rotate_token":
+				p.handleRotateTokenAction(w, r, alertConfig)
 			default:
 				http.NotFound(w, r)
 			}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPlugin returns a plugin with an empty configuration, using api with an in-memory KV
+// store and ignored logs.
+func newTestPlugin(t *testing.T) (*Plugin, *plugintest.API, *testKVStore) {
+	api := &plugintest.API{}
+	mockLogs(api)
+	store := mockKVStore(api)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+	p.rateLimiter = newChannelRateLimiter()
+	p.setConfiguration(&configuration{})
+
+	var err error
+	p.metrics, err = newMetrics()
+	require.NoError(t, err)
+
+	return p, api, store
+}
+
+// mockLogs accepts the log calls of the plugin, with up to 8 key/value pairs.
+func mockLogs(api *plugintest.API) {
+	for _, level := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		args := []interface{}{mock.AnythingOfType("string")}
+		for i := 0; i <= 8; i++ {
+			api.On(level, args...).Maybe()
+			args = append(args, mock.Anything, mock.Anything)
+		}
+	}
+}
+
+// postedChannels returns the channels of the posts created through api, in order.
+func postedChannels(api *plugintest.API) []string {
+	var channels []string
+	for _, call := range api.Calls {
+		if call.Method == "CreatePost" {
+			channels = append(channels, call.Arguments.Get(0).(*model.Post).ChannelId)
+		}
+	}
+
+	return channels
+}
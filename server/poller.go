@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	pollerJobKey         = "poller"
+	pollerJobInterval    = 15 * time.Second
+	pollerStateKeyPrefix = "poller_"
+	pollerReceiver       = "mattermost-poller"
+)
+
+// pollerState is the set of alerts notified as firing by the polls of an Alertmanager, keyed by
+// fingerprint.
+type pollerState struct {
+	LastPoll time.Time
+	Alerts   map[string]template.Alert
+}
+
+// runPollers polls every Alertmanager configured in pull mode that is due. It runs as a cluster
+// job, so only one node polls.
+func (p *Plugin) runPollers() {
+	configuration := p.getConfiguration()
+	now := time.Now()
+
+	for _, alertConfig := range configuration.AlertConfigs {
+		if alertConfig.PollInterval <= 0 {
+			continue
+		}
+
+		if err := p.pollAlertManager(alertConfig, now); err != nil {
+			p.API.LogWarn("Failed to poll alertmanager", "config", alertConfig.ID, "error", err.Error())
+		}
+	}
+}
+
+// pollAlertManager fetches the alerts of an Alertmanager and queues the firing and resolved
+// notifications handleWebhook would have received since the previous poll. The poll is only
+// recorded once its notifications are queued, so that a failed poll is repeated.
+func (p *Plugin) pollAlertManager(alertConfig alertConfig, now time.Time) error {
+	key := pollerStateKeyPrefix + alertConfig.ID
+
+	var state pollerState
+	if err := p.client.KV.Get(key, &state); err != nil {
+		return fmt.Errorf("failed to get poller state: %w", err)
+	}
+
+	if now.Sub(state.LastPoll) < time.Duration(alertConfig.PollInterval)*time.Second {
+		return nil
+	}
+
+	alerts, err := alertmanager.ListAlertsWithStatus(alertConfig.AlertManagerURL)
+	if err != nil {
+		return fmt.Errorf("failed to list alerts: %w", err)
+	}
+
+	active := make(map[string]template.Alert, len(alerts))
+	suppressed := make(map[string]bool)
+	for _, alert := range alerts {
+		fingerprint := alert.Fingerprint().String()
+		if alert.Status.State == types.AlertStateActive {
+			active[fingerprint] = convertPolledAlert(&alert.Alert)
+		} else {
+			suppressed[fingerprint] = true
+		}
+	}
+
+	messages, notified := diffPolledAlerts(state.Alerts, active, suppressed, alertConfig.AlertManagerURL, now)
+	for _, message := range messages {
+		if err := p.enqueueDelivery(alertConfig.ID, message); err != nil {
+			return err
+		}
+	}
+
+	state = pollerState{
+		LastPoll: now,
+		Alerts:   notified,
+	}
+	if _, err := p.client.KV.Set(key, state); err != nil {
+		return fmt.Errorf("failed to save poller state: %w", err)
+	}
+
+	return nil
+}
+
+func convertPolledAlert(alert *types.Alert) template.Alert {
+	labels := make(template.KV, len(alert.Labels))
+	for k, v := range alert.Labels {
+		labels[string(k)] = string(v)
+	}
+
+	annotations := make(template.KV, len(alert.Annotations))
+	for k, v := range alert.Annotations {
+		annotations[string(k)] = string(v)
+	}
+
+	return template.Alert{
+		Status:       string(prommodel.AlertFiring),
+		Labels:       labels,
+		Annotations:  annotations,
+		StartsAt:     alert.StartsAt,
+		GeneratorURL: alert.GeneratorURL,
+		Fingerprint:  alert.Fingerprint().String(),
+	}
+}
+
+// diffPolledAlerts returns the notifications for the changes between the alerts notified by the
+// previous polls and the active alerts, and the alerts notified as firing after them. Alerts are
+// grouped by alertname, and a group is notified with all of its active alerts whenever one of
+// them starts firing or disappears, which then counts as resolved. Like Alertmanager, silenced
+// and inhibited alerts are not notified, and are not resolved either while they are suppressed.
+func diffPolledAlerts(previous, active map[string]template.Alert, suppressed map[string]bool, externalURL string, now time.Time) ([]webhook.Message, map[string]template.Alert) {
+	groups := make(map[string]template.Alerts)
+	changed := make(map[string]bool)
+	notified := make(map[string]template.Alert, len(active))
+
+	for fingerprint, alert := range active {
+		name := alert.Labels[prommodel.AlertNameLabel]
+		groups[name] = append(groups[name], alert)
+		notified[fingerprint] = alert
+		if _, ok := previous[fingerprint]; !ok {
+			changed[name] = true
+		}
+	}
+
+	for fingerprint, alert := range previous {
+		if _, ok := active[fingerprint]; ok {
+			continue
+		}
+		if suppressed[fingerprint] {
+			notified[fingerprint] = alert
+			continue
+		}
+
+		name := alert.Labels[prommodel.AlertNameLabel]
+		alert.Status = string(prommodel.AlertResolved)
+		alert.EndsAt = now
+		groups[name] = append(groups[name], alert)
+		changed[name] = true
+	}
+
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]webhook.Message, 0, len(names))
+	for _, name := range names {
+		alerts := groups[name]
+		sort.Slice(alerts, func(i, j int) bool {
+			return alerts[i].Fingerprint < alerts[j].Fingerprint
+		})
+
+		groupLabels := template.KV{prommodel.AlertNameLabel: name}
+		groupKey := fmt.Sprintf("%s/%s", pollerReceiver, groupLabels)
+		messages = append(messages, newWebhookMessage(pollerReceiver, groupKey, externalURL, groupLabels, alerts))
+	}
+
+	return messages, notified
+}
+
+// newWebhookMessage builds the notification Alertmanager would send for a group of alerts.
+func newWebhookMessage(receiver, groupKey, externalURL string, groupLabels template.KV, alerts template.Alerts) webhook.Message {
+	status := string(prommodel.AlertResolved)
+	if len(alerts.Firing()) > 0 {
+		status = string(prommodel.AlertFiring)
+	}
+
+	return webhook.Message{
+		Data: &template.Data{
+			Receiver:          receiver,
+			Status:            status,
+			Alerts:            alerts,
+			GroupLabels:       groupLabels,
+			CommonLabels:      commonKV(alerts, func(a template.Alert) template.KV { return a.Labels }),
+			CommonAnnotations: commonKV(alerts, func(a template.Alert) template.KV { return a.Annotations }),
+			ExternalURL:       externalURL,
+		},
+		Version:  "4",
+		GroupKey: groupKey,
+	}
+}
+
+// commonKV returns the key/value pairs shared by all alerts.
+func commonKV(alerts template.Alerts, kv func(template.Alert) template.KV) template.KV {
+	common := template.KV{}
+	if len(alerts) == 0 {
+		return common
+	}
+
+	for k, v := range kv(alerts[0]) {
+		common[k] = v
+	}
+
+	for _, alert := range alerts[1:] {
+		pairs := kv(alert)
+		for k, v := range common {
+			if pairs[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+
+	return common
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPolledAlerts(t *testing.T) {
+	now := time.Now()
+	diskA := template.Alert{Status: "firing", Fingerprint: "a", Labels: template.KV{"alertname": "DiskFull", "instance": "a", "severity": "critical"}}
+	diskB := template.Alert{Status: "firing", Fingerprint: "b", Labels: template.KV{"alertname": "DiskFull", "instance": "b", "severity": "critical"}}
+	latency := template.Alert{Status: "firing", Fingerprint: "c", Labels: template.KV{"alertname": "HighLatency"}}
+
+	t.Run("nothing changed", func(t *testing.T) {
+		state := map[string]template.Alert{"a": diskA}
+		messages, notified := diffPolledAlerts(state, state, nil, "http://am", now)
+		assert.Empty(t, messages)
+		assert.Equal(t, state, notified)
+	})
+
+	t.Run("new alerts fire per alertname", func(t *testing.T) {
+		messages, _ := diffPolledAlerts(nil, map[string]template.Alert{"a": diskA, "b": diskB, "c": latency}, nil, "http://am", now)
+		require.Len(t, messages, 2)
+
+		assert.Equal(t, "firing", messages[0].Status)
+		assert.Equal(t, "4", messages[0].Version)
+		assert.Equal(t, "http://am", messages[0].ExternalURL)
+		assert.Len(t, messages[0].Alerts, 2)
+		assert.Equal(t, template.KV{"alertname": "DiskFull"}, messages[0].GroupLabels)
+		assert.Equal(t, template.KV{"alertname": "DiskFull", "severity": "critical"}, messages[0].CommonLabels)
+		assert.Equal(t, "HighLatency", messages[1].GroupLabels["alertname"])
+	})
+
+	t.Run("disappeared alerts resolve", func(t *testing.T) {
+		messages, notified := diffPolledAlerts(
+			map[string]template.Alert{"a": diskA, "b": diskB},
+			map[string]template.Alert{"a": diskA},
+			nil, "http://am", now,
+		)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "firing", messages[0].Status)
+		require.Len(t, messages[0].Alerts, 2)
+		assert.Equal(t, "firing", messages[0].Alerts[0].Status)
+		assert.Equal(t, "resolved", messages[0].Alerts[1].Status)
+		assert.Equal(t, now, messages[0].Alerts[1].EndsAt)
+		assert.Equal(t, map[string]template.Alert{"a": diskA}, notified)
+
+		messages, _ = diffPolledAlerts(map[string]template.Alert{"c": latency}, nil, nil, "http://am", now)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "resolved", messages[0].Status)
+	})
+
+	t.Run("suppressed alerts neither fire nor resolve", func(t *testing.T) {
+		messages, notified := diffPolledAlerts(
+			map[string]template.Alert{"a": diskA},
+			nil,
+			map[string]bool{"a": true, "c": true},
+			"http://am", now,
+		)
+		assert.Empty(t, messages)
+		assert.Equal(t, map[string]template.Alert{"a": diskA}, notified)
+
+		// An alert fires once it is no longer silenced, unless it was notified before.
+		messages, notified = diffPolledAlerts(notified, map[string]template.Alert{"a": diskA, "c": latency}, nil, "http://am", now)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "HighLatency", messages[0].GroupLabels["alertname"])
+		assert.Len(t, notified, 2)
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate float64, burst int, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// take removes a token from the bucket, and reports false if none was left.
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) bool {
+	b.refill(rate, burst, now)
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// wait returns the time until the bucket holds a token again.
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimitedAlerts buffers the notifications of a channel that exceeded its rate limit, and the
+// deliveries they belong to.
+type rateLimitedAlerts struct {
+	config      alertConfig
+	since       time.Time
+	messages    []webhook.Message
+	deliveryIDs []string
+}
+
+// channelRateLimiter limits the webhook posts per channel. Its state is kept in memory, so each
+// node of a cluster limits the notifications it delivers on its own. The deliveries of buffered
+// notifications stay queued until they are flushed, so none are lost if the node stops.
+type channelRateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	buffers map[string]*rateLimitedAlerts
+	timers  map[string]*time.Timer
+}
+
+func newChannelRateLimiter() *channelRateLimiter {
+	return &channelRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		buffers: make(map[string]*rateLimitedAlerts),
+		timers:  make(map[string]*time.Timer),
+	}
+}
+
+// rateLimitSettings returns the refill rate in tokens per second and the burst of a config.
+func rateLimitSettings(config alertConfig) (rate float64, burst int) {
+	burst = config.RateLimitBurst
+	if burst <= 0 {
+		burst = config.RateLimit
+	}
+
+	return float64(config.RateLimit) / 60, burst
+}
+
+// bufferRateLimited buffers the notification of a delivery if the channel of the config exceeded
+// its rate limit, and schedules the buffered notifications to be flushed as one post once a token
+// is available. A notification already buffered for the channel is not buffered again.
+func (p *Plugin) bufferRateLimited(config alertConfig, channelID, deliveryID string, message webhook.Message) bool {
+	if config.RateLimit <= 0 {
+		return false
+	}
+
+	rate, burst := rateLimitSettings(config)
+	limiter := p.rateLimiter
+	now := time.Now()
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	bucket, ok := limiter.buckets[channelID]
+	if !ok {
+		bucket = &tokenBucket{}
+		limiter.buckets[channelID] = bucket
+	}
+
+	// Keep notifications in order while older ones are waiting to be flushed.
+	if _, buffering := limiter.buffers[channelID]; !buffering && bucket.take(rate, burst, now) {
+		return false
+	}
+
+	buffer, ok := limiter.buffers[channelID]
+	if ok {
+		for _, id := range buffer.deliveryIDs {
+			if id == deliveryID {
+				return true
+			}
+		}
+	} else {
+		buffer = &rateLimitedAlerts{config: config, since: now}
+		limiter.buffers[channelID] = buffer
+		limiter.timers[channelID] = time.AfterFunc(bucket.wait(rate), func() {
+			p.flushRateLimited(channelID)
+		})
+	}
+	buffer.messages = append(buffer.messages, message)
+	buffer.deliveryIDs = append(buffer.deliveryIDs, deliveryID)
+
+	return true
+}
+
+// flushRateLimited posts the buffered notifications of a channel as one aggregated post, and then
+// completes their deliveries, or schedules them for a retry if the post failed.
+func (p *Plugin) flushRateLimited(channelID string) {
+	limiter := p.rateLimiter
+
+	limiter.lock.Lock()
+	buffer, ok := limiter.buffers[channelID]
+	delete(limiter.buffers, channelID)
+	delete(limiter.timers, channelID)
+	if bucket, hasBucket := limiter.buckets[channelID]; hasBucket && ok {
+		rate, burst := rateLimitSettings(buffer.config)
+		bucket.take(rate, burst, time.Now())
+	}
+	limiter.lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: channelID,
+		UserId:    p.BotUserID,
+		Message:   aggregateRateLimited(buffer, time.Now()),
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogError("Failed to post rate limited alerts", "channel", channelID, "error", appErr.Error())
+		p.metrics.recordPost(buffer.config.ID, appErr)
+		for _, id := range buffer.deliveryIDs {
+			p.finishDelivery(id, fmt.Errorf("failed to post rate limited alerts: %w", appErr), true)
+		}
+		return
+	}
+	p.metrics.recordPost(buffer.config.ID, nil)
+
+	for _, id := range buffer.deliveryIDs {
+		_, err := p.updateDelivery(id, func(d *delivery) {
+			if !d.posted(channelID) {
+				d.Posted = append(d.Posted, channelID)
+			}
+			d.ClaimedUntil = time.Time{}
+		})
+		if err != nil {
+			p.API.LogError("Failed to record the post of rate limited alerts", "delivery", id, "error", err.Error())
+			continue
+		}
+
+		// Post to the other channels of the delivery, or dequeue it.
+		p.deliver(id)
+	}
+}
+
+// flushAllRateLimited immediately posts every buffered notification.
+func (p *Plugin) flushAllRateLimited() {
+	limiter := p.rateLimiter
+	if limiter == nil {
+		return
+	}
+
+	limiter.lock.Lock()
+	channelIDs := make([]string, 0, len(limiter.timers))
+	for channelID, timer := range limiter.timers {
+		if timer.Stop() {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	limiter.lock.Unlock()
+
+	for _, channelID := range channelIDs {
+		p.flushRateLimited(channelID)
+	}
+}
+
+// aggregateRateLimited summarizes buffered notifications as a markdown table with one row per
+// alertname and status.
+func aggregateRateLimited(buffer *rateLimitedAlerts, now time.Time) string {
+	type row struct {
+		name       string
+		status     string
+		severities map[string]bool
+		count      int
+	}
+
+	rows := make(map[string]*row)
+	total := 0
+	for _, message := range buffer.messages {
+		for _, alert := range message.Alerts {
+			total++
+			name := alert.Labels[prommodel.AlertNameLabel]
+			key := name + "\x00" + alert.Status
+			r, ok := rows[key]
+			if !ok {
+				r = &row{name: name, status: alert.Status, severities: make(map[string]bool)}
+				rows[key] = r
+			}
+			if severity := alert.Labels["severity"]; severity != "" {
+				r.severities[severity] = true
+			}
+			r.count++
+		}
+	}
+
+	sorted := make([]*row, 0, len(rows))
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		if sorted[i].name != sorted[j].name {
+			return sorted[i].name < sorted[j].name
+		}
+		return sorted[i].status < sorted[j].status
+	})
+
+	msg := fmt.Sprintf("#### :chart_with_upwards_trend: %d more alerts in the last %s\n", total,
+		durafmt.Parse(now.Sub(buffer.since).Round(time.Second)).LimitFirstN(2).String(),
+	)
+	msg += fmt.Sprintf("Notifications to this channel are rate limited by AlertManager Config ID %s.\n\n", buffer.config.ID)
+	msg += "| Alert | Status | Severity | Count |\n|---|---|---|---|\n"
+	for _, r := range sorted {
+		status := strings.ToUpper(r.status)
+		if r.status == string(prommodel.AlertFiring) {
+			status = ":fire: " + status
+		}
+		severities := make([]string, 0, len(r.severities))
+		for severity := range r.severities {
+			severities = append(severities, severity)
+		}
+		sort.Strings(severities)
+		msg += fmt.Sprintf("| %s | %s | %s | %d |\n", r.name, status, strings.Join(severities, ", "), r.count)
+	}
+
+	return msg
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	rate, burst := rateLimitSettings(alertConfig{RateLimit: 6, RateLimitBurst: 2})
+	assert.Equal(t, 0.1, rate)
+	assert.Equal(t, 2, burst)
+
+	bucket := &tokenBucket{}
+	assert.True(t, bucket.take(rate, burst, now))
+	assert.True(t, bucket.take(rate, burst, now))
+	assert.False(t, bucket.take(rate, burst, now))
+	assert.Equal(t, 10*time.Second, bucket.wait(rate))
+
+	assert.False(t, bucket.take(rate, burst, now.Add(5*time.Second)))
+	assert.True(t, bucket.take(rate, burst, now.Add(10*time.Second)))
+
+	// Refills never exceed the burst.
+	assert.True(t, bucket.take(rate, burst, now.Add(time.Hour)))
+	assert.True(t, bucket.take(rate, burst, now.Add(time.Hour)))
+	assert.False(t, bucket.take(rate, burst, now.Add(time.Hour)))
+}
+
+func TestAggregateRateLimited(t *testing.T) {
+	now := time.Now()
+	alert := func(name, status, severity string) template.Alert {
+		return template.Alert{Status: status, Labels: template.KV{"alertname": name, "severity": severity}}
+	}
+
+	buffer := &rateLimitedAlerts{
+		config: alertConfig{ID: "0"},
+		since:  now.Add(-42 * time.Second),
+		messages: []webhook.Message{
+			{Data: &template.Data{Alerts: template.Alerts{alert("DiskFull", "firing", "critical"), alert("DiskFull", "firing", "warning")}}},
+			{Data: &template.Data{Alerts: template.Alerts{alert("HighLatency", "resolved", "warning"), alert("DiskFull", "firing", "critical")}}},
+		},
+	}
+
+	msg := aggregateRateLimited(buffer, now)
+	assert.Contains(t, msg, "4 more alerts in the last 42 seconds")
+	assert.Contains(t, msg, "| DiskFull | :fire: FIRING | critical, warning | 3 |\n| HighLatency | RESOLVED | warning | 1 |\n")
+}
+
+func TestRateLimitedDeliveries(t *testing.T) {
+	p, api, store := newTestPlugin(t)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+
+	config := alertConfig{ID: "0", RateLimit: 1}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+
+	queue := func() string {
+		alert := template.Alert{Status: "firing", Labels: template.KV{"alertname": "DiskFull"}, StartsAt: time.Now()}
+		d := &delivery{
+			ID:       model.NewId(),
+			ConfigID: "0",
+			Message:  newWebhookMessage("mattermost", "group", "http://alertmanager:9093", template.KV{"alertname": "DiskFull"}, template.Alerts{alert}),
+		}
+		_, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d)
+		require.NoError(t, err)
+		return d.ID
+	}
+
+	first, second := queue(), queue()
+	p.deliver(first)
+	p.deliver(second)
+	assert.Nil(t, store.get(deliveryKeyPrefix+first))
+
+	// The buffered notification stays queued, and claimed until it is flushed.
+	var buffered *delivery
+	require.NoError(t, p.client.KV.Get(deliveryKeyPrefix+second, &buffered))
+	require.NotNil(t, buffered)
+	assert.True(t, buffered.ClaimedUntil.After(time.Now().Add(deliveryLease)))
+	assert.Equal(t, []string{"alerts-id"}, postedChannels(api))
+
+	// Delivering it again, e.g. after the lease expired, does not buffer it twice.
+	_, err := p.updateDelivery(second, func(d *delivery) { d.ClaimedUntil = time.Time{} })
+	require.NoError(t, err)
+	p.deliver(second)
+
+	p.rateLimiter.lock.Lock()
+	p.rateLimiter.timers["alerts-id"].Stop()
+	p.rateLimiter.lock.Unlock()
+	p.flushRateLimited("alerts-id")
+
+	require.Len(t, postedChannels(api), 2)
+	var aggregated *model.Post
+	for _, call := range api.Calls {
+		if call.Method == "CreatePost" {
+			aggregated = call.Arguments.Get(0).(*model.Post)
+		}
+	}
+	assert.True(t, strings.HasPrefix(aggregated.Message, "#### :chart_with_upwards_trend: 1 more alerts"), aggregated.Message)
+	assert.Nil(t, store.get(deliveryKeyPrefix+second))
+	assert.Empty(t, store.keys())
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/notify/webhook"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	reminderKeyPrefix   = "reminder_"
+	reminderJobKey      = "reminders"
+	reminderJobInterval = time.Minute
+	reminderMaxInterval = 24 * time.Hour
+)
+
+// alertReminder tracks a firing alert group posted by handleWebhook until it resolves.
+type alertReminder struct {
+	ConfigID       string
+	ChannelID      string
+	PostID         string
+	FiringSince    time.Time
+	Alerts         int
+	Count          int
+	NextReminder   time.Time
+	AcknowledgedBy string
+}
+
+// reminderKey returns the KV key of the reminder for an alert group. Group keys are arbitrarily
+// long, so they are hashed to fit the KV key size limit.
+func reminderKey(configID, groupKey string) string {
+	hash := sha256.Sum256([]byte(groupKey))
+	return fmt.Sprintf("%s%s_%s", reminderKeyPrefix, configID, hex.EncodeToString(hash[:16]))
+}
+
+// nextReminderDelay doubles the reminder interval with every reminder already sent, up to
+// reminderMaxInterval.
+func nextReminderDelay(after time.Duration, count int) time.Duration {
+	delay := after
+	for i := 0; i < count && delay < reminderMaxInterval; i++ {
+		delay *= 2
+	}
+
+	if delay > reminderMaxInterval {
+		return reminderMaxInterval
+	}
+
+	return delay
+}
+
+// reminderMentions returns the first count+1 mentions of a comma-separated list, so each
+// reminder escalates to one more recipient.
+func reminderMentions(mentions string, count int) string {
+	var escalated []string
+	for _, mention := range strings.Split(mentions, ",") {
+		mention = strings.TrimSpace(mention)
+		if mention == "" {
+			continue
+		}
+		if len(escalated) > count {
+			break
+		}
+		escalated = append(escalated, mention)
+	}
+
+	return strings.Join(escalated, " ")
+}
+
+func (p *Plugin) getReminder(key string) (*alertReminder, error) {
+	var reminder *alertReminder
+	if err := p.client.KV.Get(key, &reminder); err != nil {
+		return nil, fmt.Errorf("failed to get reminder: %w", err)
+	}
+
+	return reminder, nil
+}
+
+// updateReminder atomically applies fn to the stored reminder. fn receives nil if the reminder
+// does not exist, and deletes the reminder by returning nil.
+func (p *Plugin) updateReminder(key string, fn func(reminder *alertReminder) *alertReminder) error {
+	return p.updateKV(key, func(oldValue []byte) (interface{}, error) {
+		var reminder *alertReminder
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &reminder); err != nil {
+				return nil, err
+			}
+		}
+
+		reminder = fn(reminder)
+		if reminder == nil {
+			if len(oldValue) == 0 {
+				return nil, errKVUnchanged
+			}
+			return nil, nil
+		}
+
+		return reminder, nil
+	})
+}
+
+// acknowledgeAction returns the acknowledge button for a firing alert group, or nil if
+// reminders are disabled or the group was already acknowledged.
+func (p *Plugin) acknowledgeAction(config alertConfig, message webhook.Message) *model.PostAction {
+	if config.ReminderAfter <= 0 || message.Status != "firing" {
+		return nil
+	}
+
+	key := reminderKey(config.ID, message.GroupKey)
+	reminder, err := p.getReminder(key)
+	if err != nil {
+		p.API.LogWarn("Failed to get reminder", "key", key, "error", err.Error())
+	}
+	if reminder != nil && reminder.AcknowledgedBy != "" {
+		return nil
+	}
+
+	return p.newAcknowledgeAction(config, key)
+}
+
+func (p *Plugin) newAcknowledgeAction(config alertConfig, key string) *model.PostAction {
+	return &model.PostAction{
+		Name: "Acknowledge",
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			Context: map[string]interface{}{
+				"action":       "acknowledge",
+				"reminder_key": key,
+			},
+			URL: p.getActionURL(config, "acknowledge"),
+		},
+	}
+}
+
+// trackReminder starts tracking a firing alert group posted to the alert channel, updates the
+// number of firing alerts of a tracked group, and stops once the group resolves. The tracking
+// only starts with the post, which reminders reply to, so post is nil to only update it.
+func (p *Plugin) trackReminder(config alertConfig, message webhook.Message, post *model.Post) {
+	if config.ReminderAfter <= 0 {
+		return
+	}
+
+	key := reminderKey(config.ID, message.GroupKey)
+	err := p.updateReminder(key, func(reminder *alertReminder) *alertReminder {
+		if message.Status != "firing" {
+			return nil
+		}
+
+		if reminder == nil {
+			if post == nil {
+				return nil
+			}
+
+			now := time.Now()
+			reminder = &alertReminder{
+				ConfigID:     config.ID,
+				ChannelID:    post.ChannelId,
+				PostID:       post.Id,
+				FiringSince:  now,
+				NextReminder: now.Add(time.Duration(config.ReminderAfter) * time.Minute),
+			}
+		}
+		reminder.Alerts = len(message.Alerts.Firing())
+
+		return reminder
+	})
+	if err != nil {
+		p.API.LogError("Failed to track reminder", "key", key, "error", err.Error())
+	}
+}
+
+// runReminders replies in the thread of every alert group due for a reminder. It runs as a
+// cluster job, so only one node posts.
+func (p *Plugin) runReminders() {
+	keys, err := p.listKeys(reminderKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list reminders", "error", err.Error())
+		return
+	}
+
+	configuration := p.getConfiguration()
+	now := time.Now()
+	for _, key := range keys {
+		var due *alertReminder
+		err := p.updateReminder(key, func(reminder *alertReminder) *alertReminder {
+			due = nil
+			if reminder == nil {
+				return nil
+			}
+
+			config, ok := configuration.AlertConfigs[reminder.ConfigID]
+			if !ok || config.ReminderAfter <= 0 {
+				return nil
+			}
+
+			if reminder.AcknowledgedBy != "" || reminder.NextReminder.After(now) {
+				return reminder
+			}
+
+			dueReminder := *reminder
+			due = &dueReminder
+
+			reminder.Count++
+			reminder.NextReminder = now.Add(nextReminderDelay(time.Duration(config.ReminderAfter)*time.Minute, reminder.Count))
+			return reminder
+		})
+		if err != nil {
+			p.API.LogError("Failed to update reminder", "key", key, "error", err.Error())
+			continue
+		}
+
+		if due == nil {
+			continue
+		}
+
+		if err := p.postReminder(key, due, configuration.AlertConfigs[due.ConfigID], now); err != nil {
+			p.API.LogError("Failed to post reminder, retrying on the next run", "key", key, "error", err.Error())
+			p.retryReminder(key, due, now)
+		}
+	}
+}
+
+// retryReminder schedules a reminder that failed to post for the next run, unless the group was
+// acknowledged or resolved meanwhile.
+func (p *Plugin) retryReminder(key string, due *alertReminder, now time.Time) {
+	err := p.updateReminder(key, func(reminder *alertReminder) *alertReminder {
+		if reminder == nil || reminder.PostID != due.PostID {
+			return reminder
+		}
+
+		reminder.Count = due.Count
+		reminder.NextReminder = now.Add(reminderJobInterval)
+		return reminder
+	})
+	if err != nil {
+		p.API.LogError("Failed to reschedule reminder", "key", key, "error", err.Error())
+	}
+}
+
+func (p *Plugin) postReminder(key string, reminder *alertReminder, config alertConfig, now time.Time) error {
+	msg := fmt.Sprintf(":alarm_clock: **%d alert(s) still firing** for %s and not acknowledged yet.",
+		reminder.Alerts,
+		durafmt.Parse(now.Sub(reminder.FiringSince)).LimitFirstN(2).String(),
+	)
+	if mentions := reminderMentions(config.ReminderMentions, reminder.Count); mentions != "" {
+		msg = fmt.Sprintf("%s %s", mentions, msg)
+	}
+
+	post := &model.Post{
+		ChannelId: reminder.ChannelID,
+		UserId:    p.BotUserID,
+		RootId:    reminder.PostID,
+		Message:   msg,
+	}
+
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Color:   colorFiring,
+		Actions: []*model.PostAction{p.newAcknowledgeAction(config, key)},
+	}})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+func (p *Plugin) handleAcknowledgeAction(w http.ResponseWriter, r *http.Request, alertConfig alertConfig) {
+	p.API.LogInfo("Received acknowledge action")
+
+	var action *Action
+	_ = json.NewDecoder(r.Body).Decode(&action)
+
+	if action == nil || action.Context == nil {
+		encodeEphermalMessage(w, "We could not decode the action")
+		return
+	}
+
+	key := action.Context.ReminderKey
+	if !strings.HasPrefix(key, reminderKeyPrefix+alertConfig.ID+"_") {
+		encodeEphermalMessage(w, "Invalid alert group")
+		return
+	}
+
+	var found bool
+	err := p.updateReminder(key, func(reminder *alertReminder) *alertReminder {
+		found = reminder != nil
+		if reminder != nil && reminder.AcknowledgedBy == "" {
+			reminder.AcknowledgedBy = action.UserID
+		}
+		return reminder
+	})
+	if err != nil {
+		p.API.LogError("Failed to acknowledge reminder", "key", key, "error", err.Error())
+		encodeEphermalMessage(w, "Failed to acknowledge the alert")
+		return
+	}
+
+	if !found {
+		encodeEphermalMessage(w, "This alert group is no longer firing.")
+		return
+	}
+
+	ackMsg := "Acknowledged"
+	if user, appErr := p.API.GetUser(action.UserID); appErr == nil {
+		ackMsg = fmt.Sprintf("Acknowledged by @%s", user.Username)
+	}
+
+	actionPost, appErr := p.API.GetPost(action.PostID)
+	if appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+		encodeEphermalMessage(w, ackMsg)
+		return
+	}
+
+	attachments := actionPost.Attachments()
+	for _, attachment := range attachments {
+		var actions []*model.PostAction
+		for _, actionItem := range attachment.Actions {
+			if actionItem.Integration != nil && actionItem.Integration.Context["reminder_key"] == key {
+				continue
+			}
+			actions = append(actions, actionItem)
+		}
+		attachment.Actions = actions
+	}
+	if len(attachments) > 0 {
+		attachments[len(attachments)-1].Fields = addFields(attachments[len(attachments)-1].Fields, ":white_check_mark:", ackMsg, false)
+	}
+
+	model.ParseSlackAttachment(actionPost, attachments)
+	if _, appErr := p.API.UpdatePost(actionPost); appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+	}
+
+	encodeEphermalMessage(w, ackMsg)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextReminderDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Minute, nextReminderDelay(30*time.Minute, 0))
+	assert.Equal(t, time.Hour, nextReminderDelay(30*time.Minute, 1))
+	assert.Equal(t, 4*time.Hour, nextReminderDelay(30*time.Minute, 3))
+	assert.Equal(t, reminderMaxInterval, nextReminderDelay(30*time.Minute, 10))
+	assert.Equal(t, reminderMaxInterval, nextReminderDelay(30*time.Minute, 1000))
+}
+
+func TestReminderMentions(t *testing.T) {
+	assert.Equal(t, "", reminderMentions("", 0))
+	assert.Equal(t, "@oncall", reminderMentions("@oncall, @lead,@channel", 0))
+	assert.Equal(t, "@oncall @lead", reminderMentions("@oncall, @lead,@channel", 1))
+	assert.Equal(t, "@oncall @lead @channel", reminderMentions("@oncall, @lead,@channel", 5))
+}
+
+func TestReminderKey(t *testing.T) {
+	key := reminderKey("0", `{}/{severity="critical"}:{alertname="DiskFull"}`)
+	assert.Equal(t, key, reminderKey("0", `{}/{severity="critical"}:{alertname="DiskFull"}`))
+	assert.NotEqual(t, key, reminderKey("1", `{}/{severity="critical"}:{alertname="DiskFull"}`))
+	assert.LessOrEqual(t, len(key), 50)
+}
+
+func TestTrackReminderRateLimited(t *testing.T) {
+	p, api, store := newTestPlugin(t)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		post.Id = model.NewId()
+		return post
+	}, nil)
+	serverConfig := &model.Config{}
+	serverConfig.SetDefaults()
+	api.On("GetConfig").Return(serverConfig)
+
+	config := alertConfig{ID: "0", ReminderAfter: 30, RateLimit: 1}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+	p.alertConfigIDChannelID = map[string]string{"0": "alerts-id"}
+	t.Cleanup(func() {
+		p.rateLimiter.lock.Lock()
+		defer p.rateLimiter.lock.Unlock()
+		for _, timer := range p.rateLimiter.timers {
+			timer.Stop()
+		}
+	})
+
+	notify := func(status string) error {
+		alert := template.Alert{Status: status, Labels: template.KV{"alertname": "DiskFull"}, StartsAt: time.Now()}
+		d := &delivery{
+			ID:       model.NewId(),
+			ConfigID: "0",
+			Message:  newWebhookMessage("mattermost", "group", "http://alertmanager:9093", template.KV{"alertname": "DiskFull"}, template.Alerts{alert}),
+		}
+		_, err := p.client.KV.Set(deliveryKeyPrefix+d.ID, d)
+		require.NoError(t, err)
+		return p.processWebhookMessage(config, d)
+	}
+
+	key := reminderKey("0", "group")
+	require.NoError(t, notify("firing"))
+	require.NotNil(t, store.get(key))
+
+	// The resolved notification is buffered by the rate limiter, and the reminders stop anyway.
+	assert.ErrorIs(t, notify("resolved"), errDeliveryBuffered)
+	assert.Nil(t, store.get(key))
+}
+
+func TestRunRemindersPostFailure(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(nil, model.NewAppError("CreatePost", "test.post", nil, "", http.StatusInternalServerError)).Once()
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		return post
+	}, nil)
+	serverConfig := &model.Config{}
+	serverConfig.SetDefaults()
+	api.On("GetConfig").Return(serverConfig)
+
+	config := alertConfig{ID: "0", ReminderAfter: 30}
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": config}})
+
+	key := reminderKey("0", "group")
+	now := time.Now()
+	_, err := p.client.KV.Set(key, &alertReminder{ConfigID: "0", ChannelID: "alerts-id", PostID: "post-id", FiringSince: now.Add(-time.Hour), Alerts: 1, NextReminder: now.Add(-time.Minute)})
+	require.NoError(t, err)
+
+	// The reminder that failed to post is kept and retried on the next run.
+	p.runReminders()
+	reminder, err := p.getReminder(key)
+	require.NoError(t, err)
+	require.NotNil(t, reminder)
+	assert.Equal(t, 0, reminder.Count)
+	assert.WithinDuration(t, time.Now().Add(reminderJobInterval), reminder.NextReminder, 5*time.Second)
+
+	require.NoError(t, p.updateReminder(key, func(reminder *alertReminder) *alertReminder {
+		reminder.NextReminder = now.Add(-time.Minute)
+		return reminder
+	}))
+	p.runReminders()
+	reminder, err = p.getReminder(key)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reminder.Count)
+	api.AssertNumberOfCalls(t, "CreatePost", 2)
+}
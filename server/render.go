@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	renderModeDetailed = "detailed"
+	renderModeCompact  = "compact"
+	renderModeSummary  = "summary"
+
+	expandKeyPrefix = "expand_"
+	expandTTL       = 7 * 24 * time.Hour
+
+	configIDLabel = "AlertManager Config ID"
+)
+
+// wellKnownAnnotations are rendered as links instead of being listed with the other annotations.
+var wellKnownAnnotations = []struct {
+	name  string
+	title string
+}{
+	{name: "runbook_url", title: "Runbook"},
+	{name: "dashboard_url", title: "Dashboard"},
+}
+
+// splitList splits a comma-separated list into a set, ignoring blank entries.
+func splitList(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+
+	return set
+}
+
+// filterLabels returns the labels of an alert to render, according to the label allow and deny
+// lists of the config.
+func filterLabels(config alertConfig, labels template.KV) template.KV {
+	allow := splitList(config.LabelAllowList)
+	deny := splitList(config.LabelDenyList)
+
+	filtered := make(template.KV, len(labels))
+	for k, v := range labels {
+		if len(allow) > 0 && !allow[k] {
+			continue
+		}
+		if deny[k] {
+			continue
+		}
+		filtered[k] = v
+	}
+
+	return filtered
+}
+
+// alertLinks returns the markdown links of an alert: its runbook and dashboard, the annotations
+// listed in the LinkAnnotations of the config, and a link to silence it in Alertmanager while it
+// fires.
+func alertLinks(config alertConfig, alert template.Alert, externalURL string) []string {
+	var links []string
+	for _, annotation := range wellKnownAnnotations {
+		if link := alert.Annotations[annotation.name]; link != "" {
+			links = append(links, fmt.Sprintf("[%s](%s)", annotation.title, link))
+		}
+	}
+
+	names := make([]string, 0)
+	for name := range splitList(config.LinkAnnotations) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if link := alert.Annotations[name]; link != "" {
+			links = append(links, fmt.Sprintf("[%s](%s)", annotationTitle(name), link))
+		}
+	}
+
+	if alert.Status != string(prommodel.AlertFiring) {
+		return links
+	}
+	if silence := silenceURL(externalURL, alert.Labels); silence != "" {
+		links = append(links, fmt.Sprintf("[Silence in Alertmanager](%s)", silence))
+	}
+
+	return links
+}
+
+// isLinkAnnotation reports whether an annotation is rendered as a link by alertLinks, or as the
+// title or body of an alert.
+func isLinkAnnotation(config alertConfig, name string) bool {
+	switch name {
+	case "summary", "description":
+		return true
+	}
+	for _, annotation := range wellKnownAnnotations {
+		if annotation.name == name {
+			return true
+		}
+	}
+
+	return splitList(config.LinkAnnotations)[name]
+}
+
+// annotationTitle turns an annotation name such as grafana_url into Grafana.
+func annotationTitle(name string) string {
+	name = strings.TrimSuffix(name, "_url")
+	name = strings.ReplaceAll(name, "_", " ")
+	if name == "" {
+		return name
+	}
+
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// silenceURL returns the page of the Alertmanager UI creating a silence matching labels.
+func silenceURL(externalURL string, labels template.KV) string {
+	if externalURL == "" || len(labels) == 0 {
+		return ""
+	}
+
+	matchers := make([]string, 0, len(labels))
+	for _, pair := range labels.SortedPairs() {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", pair.Name, pair.Value))
+	}
+
+	filter := "{" + strings.Join(matchers, ",") + "}"
+	return fmt.Sprintf("%s/#/silences/new?filter=%s", strings.TrimSuffix(externalURL, "/"), url.QueryEscape(filter))
+}
+
+// formatTime renders t in the timezone and time format of the config. Mattermost has no markup
+// for relative timestamps, and a relative time rendered when posting is wrong by the time the
+// post is read, so posts only show absolute times and durations.
+func formatTime(config alertConfig, t time.Time) string {
+	if config.Timezone != "" {
+		if location, err := time.LoadLocation(config.Timezone); err == nil {
+			t = t.In(location)
+		}
+	}
+
+	layout := config.TimeFormat
+	if layout == "" {
+		layout = time.RFC1123
+	}
+
+	return t.Format(layout)
+}
+
+// ConvertMessageToAttachment renders a notification in the render mode of the config.
+// Rendering never modifies the message.
+func ConvertMessageToAttachment(config alertConfig, message webhook.Message) *model.SlackAttachment {
+	switch config.RenderMode {
+	case renderModeCompact:
+		return convertMessageToCompactAttachment(config, message)
+	case renderModeSummary:
+		return convertMessageToSummaryAttachment(message)
+	default:
+		return convertMessageToDetailedAttachment(config, message)
+	}
+}
+
+func convertMessageToDetailedAttachment(config alertConfig, message webhook.Message) *model.SlackAttachment {
+	var fields []*model.SlackAttachmentField
+	for _, alert := range message.Alerts {
+		fields = append(fields, ConvertAlertToFields(config, alert, message.ExternalURL, message.Receiver)...)
+	}
+
+	return &model.SlackAttachment{
+		Fields: fields,
+		Color:  setColor(message.Status),
+	}
+}
+
+// convertMessageToCompactAttachment renders one line per alert with its distinguishing labels,
+// and the labels common to all alerts once.
+func convertMessageToCompactAttachment(config alertConfig, message webhook.Message) *model.SlackAttachment {
+	common := filterLabels(config, message.CommonLabels)
+
+	lines := make([]string, 0, len(message.Alerts))
+	for _, alert := range message.Alerts {
+		labels := filterLabels(config, alert.Labels)
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			if _, ok := common[k]; ok || k == prommodel.AlertNameLabel {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		keyLabels := make([]string, 0, len(keys))
+		for _, k := range keys {
+			keyLabels = append(keyLabels, fmt.Sprintf("%s=%s", k, labels[k]))
+		}
+
+		line := fmt.Sprintf(":white_check_mark: **%s**", alert.Labels[prommodel.AlertNameLabel])
+		duration := fmt.Sprintf("resolved after %s", durafmt.Parse(alert.EndsAt.Sub(alert.StartsAt)).LimitFirstN(2).String())
+		if alert.Status == string(prommodel.AlertFiring) {
+			line = fmt.Sprintf(":fire: **%s**", alert.Labels[prommodel.AlertNameLabel])
+			duration = fmt.Sprintf("firing since %s", formatTime(config, alert.StartsAt))
+		}
+		if len(keyLabels) > 0 {
+			line = fmt.Sprintf("%s `%s`", line, strings.Join(keyLabels, " "))
+		}
+		line = fmt.Sprintf("%s · %s", line, duration)
+		if links := alertLinks(config, alert, message.ExternalURL); len(links) > 0 {
+			line = fmt.Sprintf("%s · %s", line, strings.Join(links, " · "))
+		}
+		lines = append(lines, line)
+	}
+
+	var fields []*model.SlackAttachmentField
+	if len(common) > 0 {
+		fields = addFields(fields, "Common labels", formatKV(common), true)
+	}
+	fields = addFields(fields, configIDLabel, config.ID, true)
+
+	return &model.SlackAttachment{
+		Text:   strings.Join(lines, "\n"),
+		Fields: fields,
+		Color:  setColor(message.Status),
+	}
+}
+
+// convertMessageToSummaryAttachment renders only the number of firing and resolved alerts.
+func convertMessageToSummaryAttachment(message webhook.Message) *model.SlackAttachment {
+	firing := len(message.Alerts.Firing())
+	resolved := len(message.Alerts.Resolved())
+
+	var counts []string
+	if firing > 0 {
+		counts = append(counts, fmt.Sprintf(":fire: **%d firing**", firing))
+	}
+	if resolved > 0 {
+		counts = append(counts, fmt.Sprintf(":white_check_mark: **%d resolved**", resolved))
+	}
+
+	text := strings.Join(counts, ", ")
+	if len(message.GroupLabels) > 0 {
+		text = fmt.Sprintf("%s in group `%s`", text, message.GroupLabels.SortedPairs().String())
+	}
+
+	return &model.SlackAttachment{
+		Text:  text,
+		Color: setColor(message.Status),
+	}
+}
+
+// expandAction stores message and returns a button expanding a summary post to the detailed
+// rendering of message.
+func (p *Plugin) expandAction(config alertConfig, message webhook.Message) *model.PostAction {
+	key := expandKeyPrefix + model.NewId()
+	if _, err := p.client.KV.Set(key, message, pluginapi.SetExpiry(expandTTL)); err != nil {
+		p.API.LogWarn("Failed to save notification to expand", "error", err.Error())
+		return nil
+	}
+
+	return &model.PostAction{
+		Name: "Show details",
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			Context: map[string]interface{}{
+				"action":     "expand",
+				"expand_key": key,
+			},
+			URL: p.getActionURL(config, "expand"),
+		},
+	}
+}
+
+func (p *Plugin) handleExpandAction(w http.ResponseWriter, r *http.Request, alertConfig alertConfig) {
+	p.API.LogInfo("Received expand action")
+
+	var action *Action
+	_ = json.NewDecoder(r.Body).Decode(&action)
+
+	if action == nil || action.Context == nil || !strings.HasPrefix(action.Context.ExpandKey, expandKeyPrefix) {
+		encodeEphermalMessage(w, "We could not decode the action")
+		return
+	}
+
+	var message webhook.Message
+	if err := p.client.KV.Get(action.Context.ExpandKey, &message); err != nil || message.Data == nil {
+		encodeEphermalMessage(w, "The details of this notification are no longer available.")
+		return
+	}
+
+	actionPost, appErr := p.API.GetPost(action.PostID)
+	if appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+		encodeEphermalMessage(w, "Failed to show the details")
+		return
+	}
+
+	detailed := convertMessageToDetailedAttachment(alertConfig, message)
+	attachments := actionPost.Attachments()
+	for _, attachment := range attachments {
+		var actions []*model.PostAction
+		for _, actionItem := range attachment.Actions {
+			if actionItem.Integration != nil && actionItem.Integration.Context["expand_key"] == action.Context.ExpandKey {
+				continue
+			}
+			actions = append(actions, actionItem)
+		}
+		attachment.Actions = actions
+		attachment.Text = ""
+		attachment.Fields = detailed.Fields
+	}
+
+	model.ParseSlackAttachment(actionPost, attachments)
+	if _, appErr := p.API.UpdatePost(actionPost); appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+		encodeEphermalMessage(w, "Failed to show the details")
+		return
+	}
+
+	_ = p.client.KV.Delete(action.Context.ExpandKey)
+	encodeEphermalMessage(w, "")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the rendering tests")
+
+// TestRenderGolden renders the webhook payloads in testdata/render and compares the attachments
+// with the golden files. Run go test -update to regenerate them after a rendering change.
+func TestRenderGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload string
+		config  alertConfig
+	}{
+		{name: "firing_detailed", payload: "firing.json", config: alertConfig{ID: "0"}},
+		{name: "firing_compact", payload: "firing.json", config: alertConfig{ID: "0", RenderMode: renderModeCompact}},
+		{name: "firing_summary", payload: "firing.json", config: alertConfig{ID: "0", RenderMode: renderModeSummary}},
+		{name: "firing_filtered", payload: "firing.json", config: alertConfig{ID: "0", LabelDenyList: "job,severity"}},
+		{name: "resolved_detailed", payload: "resolved.json", config: alertConfig{ID: "1"}},
+		{name: "resolved_timezone", payload: "resolved.json", config: alertConfig{ID: "1", Timezone: "Europe/Berlin", TimeFormat: "2006-01-02 15:04 MST"}},
+		{name: "resolved_compact", payload: "resolved.json", config: alertConfig{ID: "1", RenderMode: renderModeCompact}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "render", tc.payload))
+			require.NoError(t, err)
+
+			var message, original webhook.Message
+			require.NoError(t, json.Unmarshal(payload, &message))
+			require.NoError(t, json.Unmarshal(payload, &original))
+
+			attachment := ConvertMessageToAttachment(tc.config, message)
+			assert.Equal(t, original, message, "rendering must not modify the message")
+
+			rendered, err := json.MarshalIndent(attachment, "", "  ")
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "render", tc.name+".golden.json")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, append(rendered, '\n'), 0600))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(rendered))
+		})
+	}
+}
+
+func TestFilterLabels(t *testing.T) {
+	labels := template.KV{"alertname": "DiskFull", "instance": "db-1", "job": "node", "severity": "critical"}
+
+	assert.Equal(t, labels, filterLabels(alertConfig{}, labels))
+	assert.Equal(t, template.KV{"alertname": "DiskFull", "severity": "critical"},
+		filterLabels(alertConfig{LabelDenyList: "instance, job"}, labels))
+	assert.Equal(t, template.KV{"alertname": "DiskFull"},
+		filterLabels(alertConfig{LabelAllowList: "alertname,severity", LabelDenyList: "severity"}, labels))
+}
+
+func TestConvertMessageToAttachment(t *testing.T) {
+	alerts := template.Alerts{
+		{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "instance": "db-1", "severity": "critical"}},
+		{Status: "resolved", Labels: template.KV{"alertname": "DiskFull", "instance": "db-2", "severity": "critical"}},
+	}
+	message := webhook.Message{Data: &template.Data{
+		Status:       "firing",
+		Alerts:       alerts,
+		GroupLabels:  template.KV{"alertname": "DiskFull"},
+		CommonLabels: template.KV{"alertname": "DiskFull", "severity": "critical"},
+	}}
+
+	t.Run("compact", func(t *testing.T) {
+		attachment := ConvertMessageToAttachment(alertConfig{ID: "0", RenderMode: renderModeCompact}, message)
+		assert.Contains(t, attachment.Text, ":fire: **DiskFull** `instance=db-1` · firing since")
+		assert.Contains(t, attachment.Text, ":white_check_mark: **DiskFull** `instance=db-2` · resolved after")
+		require.Len(t, attachment.Fields, 2)
+		assert.Equal(t, "**alertname:** DiskFull\n**severity:** critical\n", attachment.Fields[0].Value)
+		assert.Equal(t, "0", attachment.Fields[1].Value)
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		attachment := ConvertMessageToAttachment(alertConfig{ID: "0", RenderMode: renderModeSummary}, message)
+		assert.Equal(t, ":fire: **1 firing**, :white_check_mark: **1 resolved** in group `alertname=DiskFull`", attachment.Text)
+		assert.Empty(t, attachment.Fields)
+	})
+}
+
+func TestAlertLinks(t *testing.T) {
+	alert := template.Alert{
+		Status: "firing",
+		Labels: template.KV{"alertname": "DiskFull", "instance": "db-1"},
+		Annotations: template.KV{
+			"summary":       "Disk is full",
+			"runbook_url":   "https://runbooks/disk",
+			"dashboard_url": "https://grafana/disk",
+			"logs_url":      "https://logs/db-1",
+		},
+	}
+	config := alertConfig{LinkAnnotations: "logs_url"}
+
+	assert.Equal(t, []string{
+		"[Runbook](https://runbooks/disk)",
+		"[Dashboard](https://grafana/disk)",
+		"[Logs](https://logs/db-1)",
+		"[Silence in Alertmanager](http://am:9093/#/silences/new?filter=%7Balertname%3D%22DiskFull%22%2Cinstance%3D%22db-1%22%7D)",
+	}, alertLinks(config, alert, "http://am:9093/"))
+
+	assert.True(t, isLinkAnnotation(config, "logs_url"))
+	assert.True(t, isLinkAnnotation(config, "summary"))
+	assert.False(t, isLinkAnnotation(config, "value"))
+
+	alert.Status = "resolved"
+	assert.Len(t, alertLinks(config, alert, "http://am:9093/"), 3)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// receiverSnippet returns the Alertmanager configuration sending notifications to config, with
+// the token in the authorization header.
+func receiverSnippet(config alertConfig, siteURL string) string {
+	receiver := "mattermost-" + config.ID
+	return fmt.Sprintf(`receivers:
+  - name: %[1]s
+    webhook_configs:
+      - url: "%[2]s/plugins/%[3]s/api/webhook"
+        send_resolved: true
+        http_config:
+          authorization:
+            credentials: "%[4]s"
+
+route:
+  routes:
+    - receiver: %[1]s
+      # Only route the alerts meant for %[5]s, e.g.:
+      # matchers:
+      #   - team="%[5]s"
+      continue: true
+`, receiver, strings.TrimRight(siteURL, "/"), manifest.ID, config.Token, config.Channel)
+}
+
+// requestToken returns the token of a request, sent either as a bearer token or in the token
+// query parameter.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+func (p *Plugin) siteURL() string {
+	if siteURL := p.API.GetConfig().ServiceSettings.SiteURL; siteURL != nil {
+		return *siteURL
+	}
+
+	return ""
+}
+
+// convertSetupToSlackAttachment shows the Alertmanager configuration for config, with a button to
+// rotate its token.
+func (p *Plugin) convertSetupToSlackAttachment(config alertConfig) *model.SlackAttachment {
+	return &model.SlackAttachment{
+		Title: fmt.Sprintf("Alertmanager setup for AlertManager Config ID %s", config.ID),
+		Text: fmt.Sprintf("Add this receiver to the Alertmanager configuration at %s:\n```yaml\n%s```\nAlertmanager versions before 0.22 do not support `http_config.authorization`. Use this URL instead:\n```\n%s\n```",
+			config.AlertManagerURL, receiverSnippet(config, p.siteURL()), p.webhookURL(config)),
+		Actions: []*model.PostAction{{
+			Name:  "Rotate token",
+			Type:  model.PostActionTypeButton,
+			Style: "danger",
+			Integration: &model.PostActionIntegration{
+				Context: map[string]interface{}{
+					"action": "rotate_token",
+				},
+				URL: p.getActionURL(config, "rotate_token"),
+			},
+		}},
+	}
+}
+
+func (p *Plugin) handleSetup(args *model.CommandArgs) (string, error) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "Only system administrators can see the token of an alert configuration.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	if len(split) != 3 {
+		return "Command requires 1 parameter: AlertManager Config ID", nil
+	}
+
+	config, ok := p.getConfiguration().AlertConfigs[split[2]]
+	if !ok {
+		return fmt.Sprintf("Alert configuration %s not found", split[2]), nil
+	}
+
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{p.convertSetupToSlackAttachment(config)})
+	_ = p.API.SendEphemeralPost(args.UserId, post)
+
+	return "", nil
+}
+
+func (p *Plugin) handleRotateTokenAction(w http.ResponseWriter, r *http.Request, config alertConfig) {
+	p.API.LogInfo("Received rotate token action")
+
+	var action *Action
+	_ = json.NewDecoder(r.Body).Decode(&action)
+	if action == nil {
+		encodeEphermalMessage(w, "We could not decode the action")
+		return
+	}
+
+	if !p.API.HasPermissionTo(action.UserID, model.PermissionManageSystem) {
+		encodeEphermalMessage(w, "Only system administrators can rotate the token of an alert configuration.")
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		encodeEphermalMessage(w, fmt.Sprintf("failed to generate a token: %v", err))
+		return
+	}
+
+	rotated, err := p.updateAlertConfig(config.ID, func(config *alertConfig) error {
+		config.Token = token
+		return nil
+	})
+	if err != nil {
+		encodeEphermalMessage(w, fmt.Sprintf("failed to rotate the token: %v", err))
+		return
+	}
+
+	post := &model.Post{
+		Id:        action.PostID,
+		UserId:    p.BotUserID,
+		ChannelId: action.ChannelID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{p.convertSetupToSlackAttachment(rotated)})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&model.PostActionIntegrationResponse{
+		Update:        post,
+		EphemeralText: "Token rotated. Update the Alertmanager configuration, the previous token no longer works.",
+	})
+}
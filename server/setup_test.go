@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiverSnippet(t *testing.T) {
+	snippet := receiverSnippet(alertConfig{ID: "3", Token: "s3cr3t", Channel: "db-alerts"}, "https://mattermost.example.org/")
+
+	assert.Contains(t, snippet, "  - name: mattermost-3\n")
+	assert.Contains(t, snippet, `      - url: "https://mattermost.example.org/plugins/alertmanager/api/webhook"`)
+	assert.Contains(t, snippet, `            credentials: "s3cr3t"`)
+	assert.Contains(t, snippet, "    - receiver: mattermost-3\n")
+	assert.NotContains(t, snippet, "\t", "YAML must be indented with spaces")
+}
+
+func TestRequestToken(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/webhook?token=query", nil)
+	assert.Equal(t, "query", requestToken(r))
+
+	r.Header.Set("Authorization", "Bearer header")
+	assert.Equal(t, "header", requestToken(r))
+}
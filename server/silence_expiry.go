@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/types"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	silenceExpiryJobKey      = "silence_expiry"
+	silenceExpiryJobInterval = time.Minute
+	silenceWarningKeyPrefix  = "silence_warning_"
+	silenceExtendDuration    = time.Hour
+)
+
+// silenceWarningKey identifies a warning about one end time of a silence, so that a silence
+// extended after its warning is warned about again before its new end.
+func silenceWarningKey(configID string, silence types.Silence) string {
+	return fmt.Sprintf("%s%s_%s_%d", silenceWarningKeyPrefix, configID, silence.ID, silence.EndsAt.Unix())
+}
+
+// runSilenceExpiryWarnings warns about active silences ending within the configured window. It
+// runs as a cluster job, so only one node posts.
+func (p *Plugin) runSilenceExpiryWarnings() {
+	configuration := p.getConfiguration()
+	now := time.Now()
+
+	for _, alertConfig := range configuration.AlertConfigs {
+		if alertConfig.SilenceExpiryWarning <= 0 {
+			continue
+		}
+		window := time.Duration(alertConfig.SilenceExpiryWarning) * time.Minute
+
+		silences, err := alertmanager.ListSilences(alertConfig.AlertManagerURL)
+		if err != nil {
+			p.API.LogWarn("Failed to list silences for expiry warnings", "config", alertConfig.ID, "error", err.Error())
+			continue
+		}
+
+		for _, silence := range silences {
+			if silence.Status.State != types.SilenceStateActive || silence.EndsAt.Sub(now) > window {
+				continue
+			}
+
+			key := silenceWarningKey(alertConfig.ID, silence)
+			var warned bool
+			if err := p.client.KV.Get(key, &warned); err != nil {
+				p.API.LogWarn("Failed to get silence warning", "key", key, "error", err.Error())
+				continue
+			}
+			if warned {
+				continue
+			}
+
+			p.postSilenceExpiryWarning(alertConfig, silence, now)
+
+			if _, err := p.client.KV.Set(key, true, pluginapi.SetExpiry(window+time.Hour)); err != nil {
+				p.API.LogWarn("Failed to save silence warning", "key", key, "error", err.Error())
+			}
+		}
+	}
+}
+
+func (p *Plugin) postSilenceExpiryWarning(config alertConfig, silence types.Silence, now time.Time) {
+	attachment := p.convertSilenceExpiryToSlackAttachment(config, silence, now)
+
+	post := &model.Post{
+		ChannelId: p.getAlertChannelID(config.ID),
+		UserId:    p.BotUserID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogError("Failed to post silence expiry warning", "silence", silence.ID, "error", appErr.Error())
+	}
+
+	creator := p.getUserBySilenceCreator(silence.CreatedBy)
+	if creator == nil {
+		return
+	}
+
+	channel, appErr := p.API.GetDirectChannel(creator.Id, p.BotUserID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get direct channel with silence creator", "user", creator.Id, "error", appErr.Error())
+		return
+	}
+
+	dm := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.BotUserID,
+		Message:   "A silence you created is about to expire.",
+	}
+	model.ParseSlackAttachment(dm, []*model.SlackAttachment{p.convertSilenceExpiryToSlackAttachment(config, silence, now)})
+	if _, appErr := p.API.CreatePost(dm); appErr != nil {
+		p.API.LogError("Failed to send silence expiry warning", "user", creator.Id, "error", appErr.Error())
+	}
+}
+
+// getUserBySilenceCreator maps the free-form CreatedBy of a silence to a Mattermost user, trying
+// it as a username and then as an email address.
+func (p *Plugin) getUserBySilenceCreator(createdBy string) *model.User {
+	createdBy = strings.TrimSpace(createdBy)
+	if createdBy == "" {
+		return nil
+	}
+
+	if user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(createdBy, "@")); appErr == nil {
+		return user
+	}
+
+	if strings.Contains(createdBy, "@") {
+		if user, appErr := p.API.GetUserByEmail(createdBy); appErr == nil {
+			return user
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) convertSilenceExpiryToSlackAttachment(config alertConfig, silence types.Silence, now time.Time) *model.SlackAttachment {
+	var fields []*model.SlackAttachmentField
+	fields = addFields(fields, "Matchers", silenceMatchersString(silence), false)
+	fields = addFields(fields, "Ends", fmt.Sprintf("%s (in %s)",
+		formatTime(config, silence.EndsAt),
+		durafmt.Parse(silence.EndsAt.Sub(now)).LimitFirstN(2).String(),
+	), true)
+	fields = addFields(fields, "Created by", silence.CreatedBy, true)
+	fields = addFields(fields, "Comments", silence.Comment, false)
+	fields = addFields(fields, "AlertManager Config ID", config.ID, true)
+
+	newAction := func(name, action string) *model.PostAction {
+		return &model.PostAction{
+			Name: name,
+			Type: model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				Context: map[string]interface{}{
+					"action":     action,
+					"silence_id": silence.ID,
+				},
+				URL: p.getActionURL(config, "silence_expiry"),
+			},
+		}
+	}
+
+	return &model.SlackAttachment{
+		Title:  fmt.Sprintf(":hourglass: Silence %s is about to expire", silence.ID),
+		Fields: fields,
+		Color:  colorFiring,
+		Actions: []*model.PostAction{
+			newAction("Extend 1h", "extend"),
+			newAction("Let it expire", "dismiss"),
+		},
+	}
+}
+
+func (p *Plugin) handleSilenceExpiryAction(w http.ResponseWriter, r *http.Request, alertConfig alertConfig) {
+	p.API.LogInfo("Received silence expiry action")
+
+	var action *Action
+	_ = json.NewDecoder(r.Body).Decode(&action)
+
+	if action == nil || action.Context == nil {
+		encodeEphermalMessage(w, "We could not decode the action")
+		return
+	}
+
+	if action.Context.SilenceID == "" {
+		encodeEphermalMessage(w, "Silence ID cannot be empty")
+		return
+	}
+
+	username := "someone"
+	if user, appErr := p.API.GetUser(action.UserID); appErr == nil {
+		username = "@" + user.Username
+	}
+
+	var resultMsg string
+	switch action.Context.Action {
+	case "extend":
+		silence, err := alertmanager.ExtendSilence(action.Context.SilenceID, alertConfig.AlertManagerURL, silenceExtendDuration)
+		if err != nil {
+			encodeEphermalMessage(w, fmt.Sprintf("failed to extend the silence: %v", err))
+			return
+		}
+		resultMsg = fmt.Sprintf("Extended by %s until %s", username, formatTime(alertConfig, silence.EndsAt))
+	case "dismiss":
+		resultMsg = fmt.Sprintf("%s let the silence expire", username)
+	default:
+		encodeEphermalMessage(w, "Unknown action")
+		return
+	}
+
+	actionPost, appErr := p.API.GetPost(action.PostID)
+	if appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+		encodeEphermalMessage(w, resultMsg)
+		return
+	}
+
+	attachments := actionPost.Attachments()
+	for _, attachment := range attachments {
+		attachment.Actions = nil
+		attachment.Color = colorExpired
+		attachment.Fields = addFields(attachment.Fields, "", resultMsg, false)
+	}
+
+	model.ParseSlackAttachment(actionPost, attachments)
+	if _, appErr := p.API.UpdatePost(actionPost); appErr != nil {
+		p.API.LogError("AlerManager Update Post Error", "err=", appErr.Error())
+	}
+
+	encodeEphermalMessage(w, resultMsg)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const subscriptionsKey = "subscriptions"
+
+// subscription makes a channel receive the alerts of an alert config matching Matchers, in
+// addition to the channel of the config.
+type subscription struct {
+	ID        string
+	ConfigID  string
+	ChannelID string
+	CreatorID string
+	Matchers  string
+	CreatedAt time.Time
+}
+
+// filter returns the alerts of message matching the subscription, and false if none match.
+func (s *subscription) filter(message webhook.Message) (webhook.Message, bool) {
+	if s.Matchers == "" {
+		return message, true
+	}
+
+	matchers, err := parseMatchers(s.Matchers)
+	if err != nil {
+		return message, false
+	}
+
+	var alerts template.Alerts
+	for _, alert := range message.Alerts {
+		if matchAlert(matchers, alert) {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(alerts) == 0 {
+		return message, false
+	}
+	if len(alerts) == len(message.Alerts) {
+		return message, true
+	}
+
+	return withAlerts(message, alerts), true
+}
+
+func (p *Plugin) getSubscriptions() (map[string]*subscription, error) {
+	subscriptions := make(map[string]*subscription)
+	if err := p.client.KV.Get(subscriptionsKey, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+// updateSubscriptions atomically applies fn to the stored subscriptions.
+func (p *Plugin) updateSubscriptions(fn func(subscriptions map[string]*subscription) error) error {
+	return p.updateKV(subscriptionsKey, func(oldValue []byte) (interface{}, error) {
+		subscriptions := make(map[string]*subscription)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &subscriptions); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := fn(subscriptions); err != nil {
+			return nil, err
+		}
+
+		return subscriptions, nil
+	})
+}
+
+// postToSubscriptions posts the alerts of message to the channels subscribed to the config that
+// the delivery did not post to yet.
+func (p *Plugin) postToSubscriptions(alertConfig alertConfig, d *delivery, message webhook.Message) error {
+	subscriptions, err := p.getSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	var errs deliveryErrors
+	for _, s := range subscriptions {
+		if s.ConfigID != alertConfig.ID || s.ChannelID == p.getAlertChannelID(alertConfig.ID) {
+			continue
+		}
+
+		filtered, ok := s.filter(message)
+		if !ok {
+			continue
+		}
+
+		if err := p.postOnce(alertConfig, d, s.ChannelID, filtered); err != nil {
+			errs.add(fmt.Errorf("failed to post to subscribed channel %s: %w", s.ChannelID, err))
+		}
+	}
+
+	return errs.err()
+}
+
+// isChannelAdmin reports whether a user may manage the subscriptions of a channel.
+func (p *Plugin) isChannelAdmin(userID, channelID string) bool {
+	if p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+		return true
+	}
+
+	member, appErr := p.API.GetChannelMember(channelID, userID)
+	return appErr == nil && member.SchemeAdmin
+}
+
+// commandArgument returns the rest of command after its first n words, keeping the spaces
+// inside it, e.g. those of quoted matcher values.
+func commandArgument(command string, n int) string {
+	rest := strings.TrimSpace(command)
+	for i := 0; i < n && rest != ""; i++ {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			return ""
+		}
+		rest = strings.TrimSpace(rest[end:])
+	}
+	return rest
+}
+
+func (p *Plugin) handleSubscribe(args *model.CommandArgs) (string, error) {
+	if !p.isChannelAdmin(args.UserId, args.ChannelId) {
+		return "Only channel admins can subscribe this channel to alerts.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	if len(split) < 3 {
+		return "Command requires an AlertManager Config ID and optional matchers, e.g. `/alertmanager subscribe 0 severity=\"critical\",team=~\"db|infra\"`", nil
+	}
+
+	if _, ok := p.getConfiguration().AlertConfigs[split[2]]; !ok {
+		return fmt.Sprintf("Alert configuration %s not found", split[2]), nil
+	}
+
+	s := &subscription{
+		ID:        model.NewId(),
+		ConfigID:  split[2],
+		ChannelID: args.ChannelId,
+		CreatorID: args.UserId,
+		Matchers:  commandArgument(args.Command, 3),
+		CreatedAt: time.Now(),
+	}
+	if s.Matchers != "" {
+		if _, err := parseMatchers(s.Matchers); err != nil {
+			return fmt.Sprintf("Invalid matchers: %v", err), nil
+		}
+	}
+
+	err := p.updateSubscriptions(func(subscriptions map[string]*subscription) error {
+		subscriptions[s.ID] = s
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save the subscription: %w", err)
+	}
+
+	return fmt.Sprintf("This channel is now subscribed to the alerts of AlertManager Config ID %s. Subscription ID: %s.", s.ConfigID, s.ID), nil
+}
+
+func (p *Plugin) handleUnsubscribe(args *model.CommandArgs) (string, error) {
+	if !p.isChannelAdmin(args.UserId, args.ChannelId) {
+		return "Only channel admins can unsubscribe this channel from alerts.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	if len(split) != 3 {
+		return "Command requires 1 parameter: subscription ID, or AlertManager Config ID", nil
+	}
+
+	removed := 0
+	err := p.updateSubscriptions(func(subscriptions map[string]*subscription) error {
+		removed = 0
+		for id, s := range subscriptions {
+			if s.ChannelID == args.ChannelId && (id == split[2] || s.ConfigID == split[2]) {
+				delete(subscriptions, id)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to remove the subscription: %w", err)
+	}
+
+	if removed == 0 {
+		return fmt.Sprintf("Subscription %s not found in this channel", split[2]), nil
+	}
+
+	return fmt.Sprintf("%d subscriptions removed.", removed), nil
+}
+
+func (p *Plugin) handleSubscriptions(args *model.CommandArgs) (string, error) {
+	subscriptions, err := p.getSubscriptions()
+	if err != nil {
+		return "", err
+	}
+
+	var channelSubscriptions []*subscription
+	for _, s := range subscriptions {
+		if s.ChannelID == args.ChannelId {
+			channelSubscriptions = append(channelSubscriptions, s)
+		}
+	}
+
+	if len(channelSubscriptions) == 0 {
+		return "This channel has no subscriptions.", nil
+	}
+
+	sort.Slice(channelSubscriptions, func(i, j int) bool {
+		return channelSubscriptions[i].CreatedAt.Before(channelSubscriptions[j].CreatedAt)
+	})
+
+	msg := "| ID | AlertManager Config ID | Matchers |\n|---|---|---|\n"
+	for _, s := range channelSubscriptions {
+		matchers := "all alerts"
+		if s.Matchers != "" {
+			matchers = fmt.Sprintf("`%s`", s.Matchers)
+		}
+		msg += fmt.Sprintf("| %s | %s | %s |\n", s.ID, s.ConfigID, matchers)
+	}
+
+	return msg, nil
+}
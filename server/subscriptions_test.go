@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionFilter(t *testing.T) {
+	message := webhook.Message{Data: &template.Data{Status: "firing", Alerts: template.Alerts{
+		{Status: "firing", Labels: template.KV{"alertname": "DiskFull", "team": "db", "severity": "critical"}},
+		{Status: "resolved", Labels: template.KV{"alertname": "CPUHigh", "team": "web", "severity": "warning"}},
+	}}}
+
+	filtered, ok := (&subscription{}).filter(message)
+	assert.True(t, ok)
+	assert.Equal(t, message, filtered)
+
+	filtered, ok = (&subscription{Matchers: `team=~"db|infra",severity="critical"`}).filter(message)
+	require.True(t, ok)
+	require.Len(t, filtered.Alerts, 1)
+	assert.Equal(t, "DiskFull", filtered.Alerts[0].Labels["alertname"])
+	assert.Len(t, message.Alerts, 2, "filtering must not modify the message")
+
+	filtered, ok = (&subscription{Matchers: `team="web"`}).filter(message)
+	require.True(t, ok)
+	assert.Equal(t, "resolved", filtered.Status)
+
+	_, ok = (&subscription{Matchers: `team="mobile"`}).filter(message)
+	assert.False(t, ok)
+}
+
+func TestHandleSubscribe(t *testing.T) {
+	p, api, _ := newTestPlugin(t)
+	api.On("HasPermissionTo", "user-id", model.PermissionManageSystem).Return(true)
+	p.setConfiguration(&configuration{AlertConfigs: map[string]alertConfig{"0": {ID: "0"}}})
+
+	msg, err := p.handleSubscribe(&model.CommandArgs{UserId: "user-id", ChannelId: "channel-id", Command: `/alertmanager subscribe 0 summary="disk  full", team=~"db|infra"`})
+	require.NoError(t, err)
+	assert.Contains(t, msg, "This channel is now subscribed")
+
+	msg, err = p.handleSubscribe(&model.CommandArgs{UserId: "user-id", ChannelId: "channel-id", Command: `/alertmanager subscribe 0 summary="disk`})
+	require.NoError(t, err)
+	assert.Contains(t, msg, "Invalid matchers")
+
+	subscriptions, err := p.getSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	for _, s := range subscriptions {
+		assert.Equal(t, `summary="disk  full", team=~"db|infra"`, s.Matchers)
+
+		message := webhook.Message{Data: &template.Data{Status: "firing", Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"summary": "disk  full", "team": "db"}},
+			{Status: "firing", Labels: template.KV{"summary": "disk", "team": "db"}},
+		}}}
+		filtered, ok := s.filter(message)
+		require.True(t, ok)
+		require.Len(t, filtered.Alerts, 1)
+		assert.Equal(t, "disk  full", filtered.Alerts[0].Labels["summary"])
+	}
+}
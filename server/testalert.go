@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/cpanato/mattermost-plugin-alertmanager/server/alertmanager"
+)
+
+const (
+	testAlertName     = "MattermostTestAlert"
+	testAlertReceiver = "mattermost-test"
+	testAlertSendFlag = "--send"
+)
+
+// newTestAlert returns a synthetic alert with the given status and extra labels. It is labeled
+// test="true" and its summary says who sent it, so that posts of test alerts are recognizable.
+func newTestAlert(status string, extraLabels template.KV, username, generatorURL string, now time.Time) template.Alert {
+	labels := template.KV{
+		prommodel.AlertNameLabel: testAlertName,
+		"severity":               "info",
+	}
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+	labels["test"] = "true"
+
+	labelSet := make(prommodel.LabelSet, len(labels))
+	for k, v := range labels {
+		labelSet[prommodel.LabelName(k)] = prommodel.LabelValue(v)
+	}
+
+	alert := template.Alert{
+		Status: status,
+		Labels: labels,
+		Annotations: template.KV{
+			"summary":     fmt.Sprintf(":test_tube: Test alert sent by @%s", username),
+			"description": "This alert was sent with `/alertmanager test` to check how alerts are delivered to this channel. No action is needed.",
+		},
+		StartsAt:     now.Add(-5 * time.Minute),
+		GeneratorURL: generatorURL,
+		Fingerprint:  labelSet.Fingerprint().String(),
+	}
+	if status == string(prommodel.AlertResolved) {
+		alert.EndsAt = now
+	}
+
+	return alert
+}
+
+// parseTestParameters parses the [firing|resolved] [label=value...] [--send] parameters of the
+// test command.
+func parseTestParameters(parameters []string) (status string, labels template.KV, send bool, err error) {
+	status = string(prommodel.AlertFiring)
+	labels = template.KV{}
+
+	for i, parameter := range parameters {
+		switch {
+		case i == 0 && (parameter == string(prommodel.AlertFiring) || parameter == string(prommodel.AlertResolved)):
+			status = parameter
+		case parameter == testAlertSendFlag:
+			send = true
+		default:
+			name, value, err := parseLabel(parameter)
+			if err != nil {
+				return "", nil, false, err
+			}
+			labels[name] = value
+		}
+	}
+
+	return status, labels, send, nil
+}
+
+func (p *Plugin) handleTest(args *model.CommandArgs) (string, error) {
+	if !p.isChannelAdmin(args.UserId, args.ChannelId) {
+		return "Only channel admins can send test alerts.", nil
+	}
+
+	split := strings.Fields(args.Command)
+	if len(split) < 3 {
+		return "Command requires an AlertManager Config ID, e.g. `/alertmanager test 0 firing severity=critical`", nil
+	}
+
+	alertConfig, ok := p.getConfiguration().AlertConfigs[split[2]]
+	if !ok {
+		return fmt.Sprintf("Alert configuration %s not found", split[2]), nil
+	}
+
+	status, labels, send, err := parseTestParameters(split[3:])
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	username := args.UserId
+	if user, appErr := p.API.GetUser(args.UserId); appErr == nil {
+		username = user.Username
+	}
+
+	now := time.Now()
+	alert := newTestAlert(status, labels, username, p.siteURL(), now)
+
+	if send {
+		postable := alertmanager.PostableAlert{
+			Labels:       alert.Labels,
+			Annotations:  alert.Annotations,
+			StartsAt:     alert.StartsAt,
+			EndsAt:       alert.EndsAt,
+			GeneratorURL: alert.GeneratorURL,
+		}
+		if err := alertmanager.PostAlerts([]alertmanager.PostableAlert{postable}, alertConfig.AlertManagerURL); err != nil {
+			return fmt.Sprintf("Failed to send the test alert to Alertmanager: %v", err), nil
+		}
+
+		return fmt.Sprintf("Test alert sent to the Alertmanager of configuration %s. It is posted once Alertmanager routes it to this plugin.", alertConfig.ID), nil
+	}
+
+	groupLabels := template.KV{prommodel.AlertNameLabel: testAlertName}
+	groupKey := fmt.Sprintf("%s/%s", testAlertReceiver, groupLabels)
+	message := newWebhookMessage(testAlertReceiver, groupKey, alertConfig.AlertManagerURL, groupLabels, template.Alerts{alert})
+
+	if err := validateWebhookMessage(message, now); err != nil {
+		return fmt.Sprintf("Invalid test alert: %v", err), nil
+	}
+
+	if err := p.enqueueDelivery(alertConfig.ID, message); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Test alert queued for delivery by configuration %s.", alertConfig.ID), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTestParameters(t *testing.T) {
+	status, labels, send, err := parseTestParameters(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "firing", status)
+	assert.Empty(t, labels)
+	assert.False(t, send)
+
+	status, labels, send, err = parseTestParameters([]string{"resolved", "severity=critical", "team=db", "--send"})
+	require.NoError(t, err)
+	assert.Equal(t, "resolved", status)
+	assert.Equal(t, template.KV{"severity": "critical", "team": "db"}, labels)
+	assert.True(t, send)
+
+	_, _, _, err = parseTestParameters([]string{"severity"})
+	assert.Error(t, err)
+
+	_, _, _, err = parseTestParameters([]string{"firing", "resolved"})
+	assert.Error(t, err)
+}
+
+func TestNewTestAlert(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	firing := newTestAlert("firing", template.KV{"severity": "critical", "test": "false"}, "alice", "https://mattermost.example.org", now)
+	assert.Equal(t, testAlertName, firing.Labels["alertname"])
+	assert.Equal(t, "critical", firing.Labels["severity"])
+	assert.Equal(t, "true", firing.Labels["test"])
+	assert.Contains(t, firing.Annotations["summary"], "@alice")
+	assert.True(t, firing.EndsAt.IsZero())
+	assert.NotEmpty(t, firing.Fingerprint)
+
+	resolved := newTestAlert("resolved", template.KV{"severity": "critical"}, "alice", "", now)
+	assert.Equal(t, now, resolved.EndsAt)
+	assert.Equal(t, firing.Fingerprint, resolved.Fingerprint)
+
+	message := newWebhookMessage(testAlertReceiver, "test", "http://localhost:9093", nil, template.Alerts{resolved})
+	assert.NoError(t, validateWebhookMessage(message, now))
+}
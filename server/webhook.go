@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -14,45 +15,248 @@ import (
 	"github.com/hako/durafmt"
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"github.com/prometheus/alertmanager/template"
+	prommodel "github.com/prometheus/common/model"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 )
 
+const (
+	// maxWebhookBodySize limits the size of the notifications accepted by handleWebhook.
+	maxWebhookBodySize = 4 << 20
+
+	// maxAlertClockSkew is how far in the future an alert may start, to tolerate clocks that are
+	// not in sync.
+	maxAlertClockSkew = time.Hour
+)
+
+// apiError is the JSON body of the error responses of the plugin API.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Error: msg})
+}
+
 func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request, alertConfig alertConfig) {
 	p.API.LogInfo("Received alertmanager notification")
 
+	start := time.Now()
+	defer func() {
+		p.metrics.webhookDuration.WithLabelValues(alertConfig.ID).Observe(time.Since(start).Seconds())
+	}()
+
 	var message webhook.Message
-	err := json.NewDecoder(r.Body).Decode(&message)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBodySize)).Decode(&message)
 	if err != nil {
 		p.API.LogError("failed to decode webhook message", "err", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			p.metrics.webhookErrors.WithLabelValues(alertConfig.ID, "too_large").Inc()
+			writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", maxWebhookBodySize))
+			return
+		}
+		p.metrics.webhookErrors.WithLabelValues(alertConfig.ID, "decode").Inc()
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode webhook message: %v", err))
 		return
 	}
 
-	if message == (webhook.Message{}) {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := validateWebhookMessage(message, time.Now()); err != nil {
+		p.API.LogWarn("invalid webhook message", "config", alertConfig.ID, "err", err.Error())
+		p.metrics.webhookErrors.WithLabelValues(alertConfig.ID, "invalid").Inc()
+		writeAPIError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var fields []*model.SlackAttachmentField
-	for _, alert := range message.Alerts {
-		fields = append(fields, ConvertAlertToFields(alertConfig, alert, message.ExternalURL, message.Receiver)...)
+	p.metrics.webhooksReceived.WithLabelValues(alertConfig.ID, message.Status).Inc()
+
+	if p.isDuplicateWebhook(alertConfig, message) {
+		p.API.LogDebug("dropping duplicate webhook message", "config", alertConfig.ID, "groupKey", message.GroupKey)
+		return
+	}
+
+	if err := p.enqueueDelivery(alertConfig.ID, message); err != nil {
+		p.API.LogError("failed to enqueue webhook message", "err", err.Error())
+		p.metrics.webhookErrors.WithLabelValues(alertConfig.ID, "enqueue").Inc()
+		// Alertmanager retries notifications failing with a 5xx status, and the retry must not be
+		// dropped as a duplicate.
+		p.forgetWebhook(alertConfig, message)
+		writeAPIError(w, http.StatusInternalServerError, "failed to enqueue webhook message")
+		return
+	}
+}
+
+// validateWebhookMessage checks that a notification is a version 4 Alertmanager webhook with
+// alerts that started, and ended if resolved, at sane times.
+func validateWebhookMessage(message webhook.Message, now time.Time) error {
+	if message.Data == nil {
+		return errors.New("webhook message has no data")
+	}
+
+	if message.Version != "4" {
+		return fmt.Errorf("unsupported webhook version %q, expected \"4\"", message.Version)
 	}
 
-	attachment := &model.SlackAttachment{
-		Fields: fields,
-		Color:  setColor(message.Status),
+	if len(message.Alerts) == 0 {
+		return errors.New("webhook message has no alerts")
+	}
+
+	for i, alert := range message.Alerts {
+		switch alert.Status {
+		case string(prommodel.AlertFiring), string(prommodel.AlertResolved):
+		default:
+			return fmt.Errorf("alert %d has unknown status %q", i, alert.Status)
+		}
+
+		if alert.StartsAt.IsZero() {
+			return fmt.Errorf("alert %d has no start time", i)
+		}
+
+		if alert.StartsAt.After(now.Add(maxAlertClockSkew)) {
+			return fmt.Errorf("alert %d starts in the future at %s", i, alert.StartsAt.Format(time.RFC3339))
+		}
+
+		if alert.Status == string(prommodel.AlertResolved) {
+			if alert.EndsAt.IsZero() {
+				return fmt.Errorf("resolved alert %d has no end time", i)
+			}
+			if alert.EndsAt.Before(alert.StartsAt) {
+				return fmt.Errorf("alert %d ends before it starts", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// processWebhookMessage runs a queued Alertmanager notification, received by webhook or produced
+// by the poller, through the plugin before posting what remains of it. It posts to every channel
+// even if some fail, and a retry of the delivery only posts to the channels that failed. It
+// returns errDeliveryBuffered if posts were buffered by the rate limiter. The heartbeat and
+// flapping filters only run on the first attempt of the delivery.
+func (p *Plugin) processWebhookMessage(alertConfig alertConfig, d *delivery) error {
+	message := d.Message
+	if !d.Filtered {
+		var ok bool
+		if message, ok = p.filterHeartbeats(alertConfig, message); !ok {
+			return nil
+		}
+
+		// The reminders of resolved groups stop even if the flapping filter or the rate limiter
+		// holds back their notifications.
+		p.trackReminder(alertConfig, message, nil)
+
+		if message, ok = p.filterFlapping(alertConfig, message); !ok {
+			return nil
+		}
+
+		if err := p.markFiltered(d, message); err != nil {
+			p.API.LogError("Failed to record the filtered notification", "delivery", d.ID, "error", err.Error())
+		}
+	}
+
+	var errs deliveryErrors
+	errs.add(p.postToSubscriptions(alertConfig, d, message))
+	errs.add(p.postToIncident(alertConfig, d, message))
+	errs.add(p.postToAlertChannel(alertConfig, d, message))
+
+	return errs.err()
+}
+
+// postToAlertChannel posts a notification to the channel of the alert config. The channel is
+// ensured again if it was not found, or if the post failed, so the retry of the delivery posts to
+// the channel it was replaced with.
+func (p *Plugin) postToAlertChannel(alertConfig alertConfig, d *delivery, message webhook.Message) error {
+	channelID := p.getAlertChannelID(alertConfig.ID)
+	if channelID == "" {
+		if channelID = p.refreshAlertChannel(alertConfig); channelID == "" {
+			return fmt.Errorf("no channel for alert config %s: %s", alertConfig.ID, p.getAlertChannelError(alertConfig.ID))
+		}
+	}
+
+	err := p.postOnce(alertConfig, d, channelID, message)
+	if err != nil && !errors.Is(err, errDeliveryBuffered) {
+		p.refreshAlertChannel(alertConfig)
+	}
+
+	return err
+}
+
+// postOnce posts a notification to a channel unless an earlier attempt of the delivery did. It
+// returns errDeliveryBuffered if the channel exceeded its rate limit, and the rate limiter
+// records the post on the delivery once it flushed the notification.
+func (p *Plugin) postOnce(alertConfig alertConfig, d *delivery, channelID string, message webhook.Message) error {
+	if d.posted(channelID) {
+		return nil
+	}
+
+	if p.bufferRateLimited(alertConfig, channelID, d.ID, message) {
+		return errDeliveryBuffered
+	}
+
+	if err := p.postWebhookMessage(alertConfig, channelID, message); err != nil {
+		return err
+	}
+
+	if err := p.markPosted(d, channelID); err != nil {
+		p.API.LogError("Failed to record the post of a notification", "delivery", d.ID, "channel", channelID, "error", err.Error())
+	}
+
+	return nil
+}
+
+// postWebhookMessage posts an Alertmanager notification to a channel. Reminders are only started
+// by the posts in the channel of the alert config.
+func (p *Plugin) postWebhookMessage(alertConfig alertConfig, channelID string, message webhook.Message) error {
+	primary := channelID == p.getAlertChannelID(alertConfig.ID)
+	attachment := ConvertMessageToAttachment(alertConfig, message)
+
+	if alertConfig.RenderMode == renderModeSummary {
+		if action := p.expandAction(alertConfig, message); action != nil {
+			attachment.Actions = append(attachment.Actions, action)
+		}
+	}
+
+	if primary {
+		if action := p.acknowledgeAction(alertConfig, message); action != nil {
+			attachment.Actions = append(attachment.Actions, action)
+		}
 	}
 
 	post := &model.Post{
-		ChannelId: p.AlertConfigIDChannelID[alertConfig.ID],
+		ChannelId: channelID,
 		UserId:    p.BotUserID,
 	}
 
 	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
-	if _, appErr := p.API.CreatePost(post); appErr != nil {
-		return
+	createdPost, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		p.metrics.recordPost(alertConfig.ID, appErr)
+		return appErr
+	}
+	p.metrics.recordPost(alertConfig.ID, nil)
+
+	if primary {
+		p.trackReminder(alertConfig, message, createdPost)
 	}
+
+	return nil
+}
+
+// withAlerts returns a copy of message notifying only about alerts.
+func withAlerts(message webhook.Message, alerts template.Alerts) webhook.Message {
+	data := *message.Data
+	data.Alerts = alerts
+	data.Status = string(prommodel.AlertResolved)
+	if len(alerts.Firing()) > 0 {
+		data.Status = string(prommodel.AlertFiring)
+	}
+	message.Data = &data
+
+	return message
 }
 
 func addFields(fields []*model.SlackAttachmentField, title, msg string, short bool) []*model.SlackAttachmentField {
@@ -76,6 +280,8 @@ func setColor(impact string) string {
 	return colorExpired
 }
 
+// ConvertAlertToFields renders an alert. It does not modify the alert, whose labels may
+// be shared with the other alerts of a notification.
 func ConvertAlertToFields(config alertConfig, alert template.Alert, externalURL, receiver string) []*model.SlackAttachmentField {
 	var fields []*model.SlackAttachmentField
 
@@ -84,10 +290,19 @@ func ConvertAlertToFields(config alertConfig, alert template.Alert, externalURL,
 		statusMsg = fmt.Sprintf(":fire: %s :fire:", strings.ToUpper(alert.Status))
 	}
 
-	/* first field: Annotations, Start/End, Source */
+	/* first field: Summary, Description, Annotations, Start/End, Links, Source */
 	var msg string
+	if summary := alert.Annotations["summary"]; summary != "" {
+		msg = fmt.Sprintf("**%s**\n", summary)
+	}
+	if description := alert.Annotations["description"]; description != "" {
+		msg = fmt.Sprintf("%s%s\n\n", msg, description)
+	}
 	annotations := make([]string, 0, len(alert.Annotations))
 	for k := range alert.Annotations {
+		if isLinkAnnotation(config, k) {
+			continue
+		}
 		annotations = append(annotations, k)
 	}
 	sort.Strings(annotations)
@@ -95,30 +310,30 @@ func ConvertAlertToFields(config alertConfig, alert template.Alert, externalURL,
 		msg = fmt.Sprintf("%s**%s:** %s\n", msg, cases.Title(language.Und, cases.NoLower).String(k), alert.Annotations[k])
 	}
 	msg = fmt.Sprintf("%s \n", msg)
-	msg = fmt.Sprintf("%s**Started at:** %s (%s ago)\n", msg,
-		(alert.StartsAt).Format(time.RFC1123),
-		durafmt.Parse(time.Since(alert.StartsAt)).LimitFirstN(2).String(),
-	)
+	msg = fmt.Sprintf("%s**Started at:** %s\n", msg, formatTime(config, alert.StartsAt))
 	if alert.Status == "resolved" {
-		msg = fmt.Sprintf("%s**Ended at:** %s (%s ago)\n", msg,
-			(alert.EndsAt).Format(time.RFC1123),
-			durafmt.Parse(time.Since(alert.EndsAt)).LimitFirstN(2).String(),
+		msg = fmt.Sprintf("%s**Ended at:** %s (after %s)\n", msg,
+			formatTime(config, alert.EndsAt),
+			durafmt.Parse(alert.EndsAt.Sub(alert.StartsAt)).LimitFirstN(2).String(),
 		)
 	}
+	if links := alertLinks(config, alert, externalURL); len(links) > 0 {
+		msg = fmt.Sprintf("%s%s\n", msg, strings.Join(links, " · "))
+	}
 	msg = fmt.Sprintf("%s \n", msg)
 	msg = fmt.Sprintf("%sGenerated by a [Prometheus Alert](%s) and sent to the [Alertmanager](%s) '%s' receiver.", msg, alert.GeneratorURL, externalURL, receiver)
 	fields = addFields(fields, statusMsg, msg, true)
 
-	/* second field: Labels only */
-	msg = ""
-	alert.Labels["AlertManager Config ID"] = config.ID
-	labels := make([]string, 0, len(alert.Labels))
-	for k := range alert.Labels {
+	/* second field: Config ID and Labels */
+	msg = fmt.Sprintf("**%s:** %s\n", configIDLabel, config.ID)
+	alertLabels := filterLabels(config, alert.Labels)
+	labels := make([]string, 0, len(alertLabels))
+	for k := range alertLabels {
 		labels = append(labels, k)
 	}
 	sort.Strings(labels)
 	for _, k := range labels {
-		msg = fmt.Sprintf("%s**%s:** %s\n", msg, cases.Title(language.Und, cases.NoLower).String(k), alert.Labels[k])
+		msg = fmt.Sprintf("%s**%s:** %s\n", msg, cases.Title(language.Und, cases.NoLower).String(k), alertLabels[k])
 	}
 
 	fields = addFields(fields, "", msg, true)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWebhookMessage(t *testing.T) {
+	now := time.Now()
+	message := func(alerts ...template.Alert) webhook.Message {
+		return webhook.Message{Version: "4", Data: &template.Data{Alerts: alerts}}
+	}
+	firing := template.Alert{Status: "firing", StartsAt: now.Add(-time.Hour)}
+	resolved := template.Alert{Status: "resolved", StartsAt: now.Add(-time.Hour), EndsAt: now}
+
+	assert.NoError(t, validateWebhookMessage(message(firing, resolved), now))
+
+	for name, tc := range map[string]struct {
+		message webhook.Message
+		err     string
+	}{
+		"no data":          {message: webhook.Message{Version: "4"}, err: "webhook message has no data"},
+		"version":          {message: webhook.Message{Version: "3", Data: &template.Data{Alerts: template.Alerts{firing}}}, err: `unsupported webhook version "3", expected "4"`},
+		"no alerts":        {message: message(), err: "webhook message has no alerts"},
+		"unknown status":   {message: message(firing, template.Alert{Status: "pending", StartsAt: now}), err: `alert 1 has unknown status "pending"`},
+		"no start":         {message: message(template.Alert{Status: "firing"}), err: "alert 0 has no start time"},
+		"future start":     {message: message(template.Alert{Status: "firing", StartsAt: now.Add(2 * time.Hour)}), err: "alert 0 starts in the future"},
+		"no end":           {message: message(template.Alert{Status: "resolved", StartsAt: now}), err: "resolved alert 0 has no end time"},
+		"end before start": {message: message(template.Alert{Status: "resolved", StartsAt: now, EndsAt: now.Add(-time.Minute)}), err: "alert 0 ends before it starts"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := validateWebhookMessage(tc.message, now)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
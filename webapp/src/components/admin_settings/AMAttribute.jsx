@@ -9,6 +9,7 @@ const AMAttribute = (props) => {
         token: "",
 
     } : {
+        ...props.attributes,
         alertmanagerurl: props.attributes.alertmanagerurl? props.attributes.alertmanagerurl: "",
         channel: props.attributes.channel? props.attributes.channel : "",
         team: props.attributes.team ? props.attributes.team: "",
@@ -70,6 +71,28 @@ const AMAttribute = (props) => {
         props.onChange({id: props.id, attributes: newSettings});
     }
 
+    const handleNumberInput = (settingName) => (e) => {
+        const value = parseInt(e.target.value, 10);
+        const newSettings = {...settings, [settingName]: isNaN(value) ? 0 : value};
+
+        setSettings(newSettings);
+        props.onChange({id: props.id, attributes: newSettings});
+    }
+
+    const handleOptionalStringInput = (settingName) => (e) => {
+        const newSettings = {...settings, [settingName]: e.target.value};
+
+        setSettings(newSettings);
+        props.onChange({id: props.id, attributes: newSettings});
+    }
+
+    const handleBoolInput = (settingName) => (e) => {
+        const newSettings = {...settings, [settingName]: e.target.value === 'true'};
+
+        setSettings(newSettings);
+        props.onChange({id: props.id, attributes: newSettings});
+    }
+
     const handleDelete = (e) => {
         props.onDelete(props.id);
     }
@@ -100,7 +123,30 @@ const AMAttribute = (props) => {
                     className="form-control"
                     type="input"
                     onChange={onChangeFunction}
-                    value={settings[settingName]}
+                    value={settings[settingName] ? settings[settingName] : ""}
+                />
+                <div className="help-text">
+                    {helpTextJSX}
+                </div>
+            </div>
+        </div>
+        );
+    }
+
+    const generateNumberInputSetting = ( title, settingName, onChangeFunction, helpTextJSX) => {
+        return (
+            <div className="form-group" >
+            <label className="control-label col-sm-4">
+                {title}
+            </label>
+            <div className="col-sm-8">
+                <input
+                    id={`PluginSettings.Plugins.alertmanager.${settingName + "." + settings.id}`}
+                    className="form-control"
+                    type="number"
+                    min="0"
+                    onChange={onChangeFunction}
+                    value={settings[settingName] ? settings[settingName] : 0}
                 />
                 <div className="help-text">
                     {helpTextJSX}
@@ -110,6 +156,66 @@ const AMAttribute = (props) => {
         );
     }
 
+    const generateSelectSetting = ( title, settingName, options, onChangeFunction, helpTextJSX) => {
+        return (
+            <div className="form-group" >
+            <label className="control-label col-sm-4">
+                {title}
+            </label>
+            <div className="col-sm-8">
+                <select
+                    id={`PluginSettings.Plugins.alertmanager.${settingName + "." + settings.id}`}
+                    className="form-control"
+                    onChange={onChangeFunction}
+                    value={settings[settingName] ? settings[settingName] : options[0]}
+                >
+                    {options.map((option) => (
+                        <option key={option} value={option}>{option}</option>
+                    ))}
+                </select>
+                <div className="help-text">
+                    {helpTextJSX}
+                </div>
+            </div>
+        </div>
+        );
+    }
+
+    const generateBoolSetting = ( title, settingName, onChangeFunction, helpTextJSX) => {
+        return (
+            <div className="form-group" >
+            <label className="control-label col-sm-4">
+                {title}
+            </label>
+            <div className="col-sm-8">
+                <label className="radio-inline">
+                    <input
+                        type="radio"
+                        name={`PluginSettings.Plugins.alertmanager.${settingName + "." + settings.id}`}
+                        value="true"
+                        checked={settings[settingName] === true}
+                        onChange={onChangeFunction}
+                    />
+                    {"true"}
+                </label>
+                <label className="radio-inline">
+                    <input
+                        type="radio"
+                        name={`PluginSettings.Plugins.alertmanager.${settingName + "." + settings.id}`}
+                        value="false"
+                        checked={settings[settingName] !== true}
+                        onChange={onChangeFunction}
+                    />
+                    {"false"}
+                </label>
+                <div className="help-text">
+                    {helpTextJSX}
+                </div>
+            </div>
+        </div>
+        );
+    }
+
     const generateGeneratedFieldSetting = ( title, settingName, regenerateFunction, regenerateText, helpTextJSX) => {
         return (<div className="form-group" >
         <label className="control-label col-sm-4">
@@ -163,7 +269,24 @@ const AMAttribute = (props) => {
                         "Channel Name:",
                         "channel",
                         handleChannelNameInput,
-                        (<span>{"Channel you want to send messages to. Use the channel name such as 'town-square', instead of the display name. If you specify a channel that does not exist, this plugin creates a new channel with that name."}</span>)
+                        (<span>{"Channel you want to send messages to. Use the channel name such as 'town-square', instead of the display name, or the channel ID. If you specify a channel that does not exist, this plugin creates a new channel with that name."}</span>)
+                        )
+                    }
+
+                    { generateSelectSetting(
+                        "Channel Type:",
+                        "channeltype",
+                        ["public", "private"],
+                        handleOptionalStringInput("channeltype"),
+                        (<span>{"Type of the channel created when the channel does not exist. The bot is added to private channels, invite the members of the channel yourself."}</span>)
+                        )
+                    }
+
+                    { generateBoolSetting(
+                        "Disable Channel Creation:",
+                        "disablechannelcreation",
+                        handleBoolInput("disablechannelcreation"),
+                        (<span>{"When true, a channel that does not exist is reported instead of being created."}</span>)
                         )
                     }
 
@@ -183,6 +306,188 @@ const AMAttribute = (props) => {
                         (<span>{"The URL of your AlertManager instance, e.g. \'"}<a href="http://alertmanager.example.com/" rel="noopener noreferrer" target="_blank">{"http://alertmanager.example.com/"}</a>{"\'"}</span>)
                         )
                     }
+
+                    { generateNumberInputSetting(
+                        "Reminder After (minutes):",
+                        "reminderafter",
+                        handleNumberInput("reminderafter"),
+                        (<span>{"Reply in the alert thread when an alert is still firing and not acknowledged after this many minutes. Reminders back off exponentially. Set to 0 to disable reminders."}</span>)
+                        )
+                    }
+
+                    { generateSimpleStringInputSetting(
+                        "Reminder Mentions:",
+                        "remindermentions",
+                        handleOptionalStringInput("remindermentions"),
+                        (<span>{"Comma-separated mentions escalated with each reminder, e.g. \'@oncall,@sre-lead,@channel\'. The first reminder mentions the first entry, the second the first two, and so on."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Silence Expiry Warning (minutes):",
+                        "silenceexpirywarning",
+                        handleNumberInput("silenceexpirywarning"),
+                        (<span>{"Warn in the channel, and the silence creator by direct message, when an active silence ends within this many minutes. Set to 0 to disable warnings."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Poll Interval (seconds):",
+                        "pollinterval",
+                        handleNumberInput("pollinterval"),
+                        (<span>{"Poll the AlertManager API for alerts instead of waiting for webhooks, for AlertManagers that cannot reach Mattermost. Polls run at most every 15 seconds. Set to 0 to only receive webhooks."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Health Check Failures:",
+                        "healthcheckfailures",
+                        handleNumberInput("healthcheckfailures"),
+                        (<span>{"Check the AlertManager status every minute, and report it unreachable after this many consecutive failures. Recoveries and restarts are reported too. Set to 0 to disable health checks."}</span>)
+                        )
+                    }
+
+                    { generateSimpleStringInputSetting(
+                        "Health Check Channel:",
+                        "healthcheckchannel",
+                        handleOptionalStringInput("healthcheckchannel"),
+                        (<span>{"Channel in the same team receiving health reports, such as 'ops-admins'. Leave empty to use the alert channel."}</span>)
+                        )
+                    }
+
+                    { generateSimpleStringInputSetting(
+                        "Heartbeat Matcher:",
+                        "heartbeatmatcher",
+                        handleOptionalStringInput("heartbeatmatcher"),
+                        (<span>{"Matcher selecting an always-firing alert such as 'alertname=\"Watchdog\"'. Matching alerts are not posted, but prove that the alerting pipeline works."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Heartbeat Timeout (minutes):",
+                        "heartbeattimeout",
+                        handleNumberInput("heartbeattimeout"),
+                        (<span>{"Report the alerting pipeline as broken when no heartbeat was received for this many minutes. It should be larger than the repeat interval of the heartbeat route. Set to 0 to disable the check."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Flapping Threshold:",
+                        "flapthreshold",
+                        handleNumberInput("flapthreshold"),
+                        (<span>{"Number of firing/resolved transitions within the flapping window after which an alert is flapping. Transitions of flapping alerts are collapsed into a single post. Set to 0 to disable flapping detection."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Flapping Window (minutes):",
+                        "flapwindow",
+                        handleNumberInput("flapwindow"),
+                        (<span>{"Sliding window in which transitions are counted. Defaults to 30 minutes."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Flapping Stable Period (minutes):",
+                        "flapstableperiod",
+                        handleNumberInput("flapstableperiod"),
+                        (<span>{"A flapping alert without transitions for this long is no longer flapping. Defaults to 30 minutes."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Rate Limit (posts per minute):",
+                        "ratelimit",
+                        handleNumberInput("ratelimit"),
+                        (<span>{"Maximum number of alert posts per minute in the channel. Notifications over the limit are aggregated into a single summary post. Set to 0 to disable rate limiting."}</span>)
+                        )
+                    }
+
+                    { generateNumberInputSetting(
+                        "Rate Limit Burst:",
+                        "ratelimitburst",
+                        handleNumberInput("ratelimitburst"),
+                        (<span>{"Number of alert posts allowed in a burst before the rate limit applies. Defaults to the rate limit."}</span>)
+                        )
+                    }
+                    { generateSelectSetting(
+                        "Render Mode:",
+                        "rendermode",
+                        ["detailed", "compact", "summary"],
+                        handleOptionalStringInput("rendermode"),
+                        (<span>{"How alert posts render: detailed fields per alert, one compact line per alert, or a summary of counts with a button to show the details."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Label Allow List:",
+                        "labelallowlist",
+                        handleOptionalStringInput("labelallowlist"),
+                        (<span>{"Comma-separated labels to show in alert posts. Leave empty to show all labels."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Label Deny List:",
+                        "labeldenylist",
+                        handleOptionalStringInput("labeldenylist"),
+                        (<span>{"Comma-separated labels to hide from alert posts, such as 'instance,job,prometheus'."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Link Annotations:",
+                        "linkannotations",
+                        handleOptionalStringInput("linkannotations"),
+                        (<span>{"Comma-separated annotations holding URLs to render as links, such as 'grafana_url,logs_url'. The runbook_url and dashboard_url annotations are always rendered as links."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Timezone:",
+                        "timezone",
+                        handleOptionalStringInput("timezone"),
+                        (<span>{"IANA timezone of the times shown in alert posts, such as 'Europe/Berlin'. Defaults to the timezone of the alert."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Time Format:",
+                        "timeformat",
+                        handleOptionalStringInput("timeformat"),
+                        (<span>{"Go time layout of the times shown in alert posts, such as '2006-01-02 15:04 MST'. Defaults to 'Mon, 02 Jan 2006 15:04:05 MST'."}</span>)
+                        )
+                    }
+                    { generateNumberInputSetting(
+                        "Deduplication Window (seconds):",
+                        "dedupwindow",
+                        handleNumberInput("dedupwindow"),
+                        (<span>{"Drop notifications identical to one received within this many seconds, such as the copy sent by the other replica of an Alertmanager HA pair. Zero disables deduplication."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Incident Matcher:",
+                        "incidentmatcher",
+                        handleOptionalStringInput("incidentmatcher"),
+                        (<span>{"Matchers selecting the alert groups that get a dedicated incident channel, such as 'severity=\"critical\"'. Leave empty to disable incident channels."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Incident Channel Template:",
+                        "incidentchanneltemplate",
+                        handleOptionalStringInput("incidentchanneltemplate"),
+                        (<span>{"Name of the incident channels, where {{label}} is replaced by a label of the alert group and {{date}} by the date. Defaults to 'inc-{{alertname}}-{{date}}'."}</span>)
+                        )
+                    }
+                    { generateSimpleStringInputSetting(
+                        "Incident Responders:",
+                        "incidentresponders",
+                        handleOptionalStringInput("incidentresponders"),
+                        (<span>{"Comma-separated usernames added to the incident channels, such as '@oncall,@sre-lead'."}</span>)
+                        )
+                    }
+                    { generateNumberInputSetting(
+                        "Incident Archive Delay (minutes):",
+                        "incidentarchiveafter",
+                        handleNumberInput("incidentarchiveafter"),
+                        (<span>{"Archive the incident channel this many minutes after the alert group resolves. Zero keeps the channel."}</span>)
+                        )
+                    }
                 </div>
             </div>
         </div>
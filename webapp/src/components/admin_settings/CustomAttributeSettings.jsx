@@ -92,12 +92,7 @@ const CustomAttributesSettings = (props) => {
                     orderNumber={index}
                     onChange={handleChange}
                     onDelete={triggerDeleteModal}
-                    attributes = {{
-                        team: value.team,
-                        channel: value.channel,
-                        token: value.token,
-                        alertmanagerurl: value.alertmanagerurl
-                    }}
+                    attributes = {value}
                 />
             );
         });